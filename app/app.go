@@ -0,0 +1,267 @@
+// Package app 把 service.Server 包装成一个可配置的服务端程序: 从配置文件读取监听地址和各项限制,
+// 同时在多个地址上监听, 可选地向注册中心发送心跳, 收到 SIGINT 或 SIGTERM 时优雅关闭
+// 服务通过插件注册, 见 plugin.go; cmd/server 是一个例子
+package app
+
+import (
+	"GeeRPC/service"
+	"GeeRPC/trace"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// http 监听地址上 JSON-RPC 网关的路径, WebSocket 和 REST 使用 service 中的默认路径
+const JSONRPCPath = "/jsonrpc"
+
+// Main 解析 -config 参数, 运行服务端直到收到 SIGINT 或 SIGTERM, 出错时退出进程
+func Main() {
+	path := flag.String("config", "", "path of the JSON config file, defaults to tcp on :9007")
+	flag.Parse()
+	cfg := DefaultConfig()
+	if *path != "" {
+		var err error
+		if cfg, err = LoadConfig(*path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := Run(ctx, cfg)
+	stop()
+	if err != nil {
+		slog.Error("app: exit", "err", err)
+		os.Exit(1)
+	}
+}
+
+// NewServer 按配置创建 service.Server 并安装启用的插件
+func NewServer(cfg *Config) (*service.Server, error) {
+	server := &service.Server{
+		HandleTimeout:     time.Duration(cfg.HandleTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		HeartbeatInterval: time.Duration(cfg.HeartbeatInterval),
+		MaxHeaderSize:     cfg.MaxHeaderSize,
+		MaxBodySize:       cfg.MaxBodySize,
+		MaxInFlight:       cfg.MaxInFlight,
+		MaxConnInFlight:   cfg.MaxConnInFlight,
+		MaxQueue:          cfg.MaxQueue,
+		MaxAbandoned:      cfg.MaxAbandoned,
+	}
+	if cfg.Log != nil {
+		h, err := cfg.Log.handler()
+		if err != nil {
+			return nil, fmt.Errorf("app: log: %w", err)
+		}
+		server.Logger = slog.New(h)
+	}
+	if c := cfg.AccessLog; c != nil {
+		server.AccessLog = &service.AccessLog{SampleEvery: c.SampleEvery, SlowThreshold: time.Duration(c.SlowThreshold)}
+		if server.Logger != nil {
+			server.AccessLog.Handler = server.Logger.Handler()
+		}
+	}
+	if cfg.Trace != nil {
+		w, err := cfg.Trace.writer()
+		if err != nil {
+			return nil, fmt.Errorf("app: trace: %w", err)
+		}
+		exporter := trace.NewJSONExporter(w)
+		exporter.Logger = server.Logger
+		server.Tracer = &trace.Tracer{Exporter: exporter}
+	}
+	if err := installPlugins(server, cfg.Services); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Run 按配置启动服务端, ctx 结束后优雅关闭: 先从注册中心注销, 再等待调用结束;
+// 所有连接关闭后返回 nil, 超过 ShutdownTimeout 时返回 context.DeadlineExceeded
+func Run(ctx context.Context, cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		return err
+	}
+	return serve(ctx, cfg, server)
+}
+
+// 一个正在监听的地址
+type listener struct {
+	net.Listener
+	http      *http.Server // network 为 http 时不为空
+	advertise string
+}
+
+func listen(c ListenerConfig) (*listener, error) {
+	network := c.Network
+	if network == NetworkHTTP {
+		network = "tcp"
+	}
+	l, err := service.Listen(network, c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.load()
+		if err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+		l = tls.NewListener(l, tlsConfig)
+	}
+	advertise := c.Advertise
+	if advertise == "" {
+		advertise = c.Network + "@" + l.Addr().String()
+		if c.Network == NetworkHTTP {
+			advertise = "ws@ws://" + l.Addr().String() + service.DefaultWebSocketPath
+		}
+	}
+	return &listener{Listener: l, advertise: advertise}, nil
+}
+
+// http 监听地址上的 WebSocket、REST 和 JSON-RPC 网关
+func httpHandler(server *service.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(service.DefaultWebSocketPath, server.WebSocketHandler())
+	mux.Handle(service.DefaultRESTPath, server.RESTHandler())
+	mux.Handle(JSONRPCPath, server.JSONRPCHandler())
+	return mux
+}
+
+// 调试地址上的指标和 pprof
+func debugHandler(server *service.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(service.DefaultMetricsPath, server.MetricsHandler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func serve(ctx context.Context, cfg *Config, server *service.Server) error {
+	logger := slog.Default()
+	if server.Logger != nil {
+		logger = server.Logger
+	}
+	var listeners []*listener
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+	for _, c := range cfg.Listeners {
+		l, err := listen(c)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("app: listen %s %s: %w", c.Network, c.Addr, err)
+		}
+		if c.Network == NetworkHTTP {
+			l.http = &http.Server{Handler: httpHandler(server)}
+		}
+		listeners = append(listeners, l)
+	}
+	var debug *http.Server
+	var debugListener net.Listener
+	if cfg.DebugAddr != "" {
+		var err error
+		if debugListener, err = net.Listen("tcp", cfg.DebugAddr); err != nil {
+			closeAll()
+			return fmt.Errorf("app: listen debug %s: %w", cfg.DebugAddr, err)
+		}
+		debug = &http.Server{Handler: debugHandler(server)}
+	}
+
+	// http.Server 意外退出时关闭整个服务端
+	serveErr := make(chan error, len(listeners)+1)
+	serveHTTP := func(hs *http.Server, l net.Listener) {
+		if err := hs.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}
+	var advertised []string
+	for _, l := range listeners {
+		logger.Info("app: listening", "addr", l.Addr(), "advertise", l.advertise)
+		advertised = append(advertised, l.advertise)
+		if l.http != nil {
+			go serveHTTP(l.http, l)
+		} else {
+			go server.Accept(l)
+		}
+	}
+	if debug != nil {
+		logger.Info("app: debug listening", "addr", debugListener.Addr())
+		go serveHTTP(debug, debugListener)
+	}
+	var registryDone sync.WaitGroup
+	stopRegistry := func() {}
+	if cfg.Registry != nil {
+		var regCtx context.Context
+		regCtx, stopRegistry = context.WithCancel(context.Background())
+		registryDone.Add(1)
+		go func() {
+			defer registryDone.Done()
+			heartbeat(regCtx, cfg.Registry, advertised, logger)
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		logger.Info("app: shutting down", "timeout", cfg.shutdownTimeout())
+	case err = <-serveErr:
+		logger.Error("app: serve failed, shutting down", "err", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout())
+	defer cancel()
+	stopRegistry()
+	registryDone.Wait()
+	if cfg.Registry != nil {
+		deregister(shutdownCtx, cfg.Registry, advertised, logger)
+	}
+
+	// 网关和原生连接同时关闭, 指标在最后关闭, 关闭期间仍然可以查看
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners)+1)
+	for i, l := range listeners {
+		if l.http == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, hs *http.Server) {
+			defer wg.Done()
+			errs[i] = hs.Shutdown(shutdownCtx)
+		}(i, l.http)
+	}
+	errs[len(listeners)] = server.Shutdown(shutdownCtx)
+	wg.Wait()
+	if debug != nil {
+		_ = debug.Close()
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+	logger.Info("app: shutdown complete")
+	return nil
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"GeeRPC/service"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Echo int
+
+func (e Echo) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func init() {
+	Register("Echo", func(server *service.Server) error { return server.Register(new(Echo)) })
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(s string) string {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := LoadConfig(write(`{
+		"listeners": [{"network": "tcp", "addr": ":9007"}, {"network": "http", "addr": ":8080"}],
+		"handle_timeout": "1.5s",
+		"max_in_flight": 10
+	}`))
+	if err != nil {
+		t.Fatalf("load config failed: %v", err)
+	}
+	if len(cfg.Listeners) != 2 || cfg.HandleTimeout != Duration(1500*time.Millisecond) || cfg.MaxInFlight != 10 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if cfg.shutdownTimeout() != DefaultShutdownTimeout {
+		t.Fatalf("expect default shutdown timeout, got %v", cfg.shutdownTimeout())
+	}
+
+	for _, bad := range []string{
+		`{"listeners": [{"network": "tcp", "addr": ":9007"}], "handle_timeot": "1s"}`,
+		`{"listeners": [{"network": "tcp", "addr": ":9007"}], "handle_timeout": 1}`,
+		`{"listeners": [{"network": "udp", "addr": ":9007"}]}`,
+		`{"listeners": [{"network": "tcp", "addr": ":9007", "tls": {"cert_file": "a.pem"}}]}`,
+		`{"listeners": [{"network": "http", "addr": ":8443", "tls": {"cert_file": "a.pem", "key_file": "a.key"}}]}`,
+		`{"listeners": []}`,
+		`{"listeners": [{"network": "tcp", "addr": ":9007"}], "log": {"level": "verbose"}}`,
+		`{"listeners": [{"network": "tcp", "addr": ":9007"}], "log": {"format": "xml"}}`,
+		`{"listeners": [{"network": "tcp", "addr": ":9007"}], "trace": {"output": "spans.log"}}`,
+	} {
+		if _, err := LoadConfig(write(bad)); err == nil {
+			t.Fatalf("expect error for %s", bad)
+		}
+	}
+}
+
+func TestNewServer_LogAndTrace(t *testing.T) {
+	cfg := &Config{
+		Listeners: []ListenerConfig{{Network: "tcp", Addr: ":0"}},
+		Services:  []string{"Echo"},
+		Log:       &LogConfig{Level: "warn", Format: "json"},
+		AccessLog: &AccessLogConfig{},
+		Trace:     &TraceConfig{Output: "stderr"},
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	if server.Logger == nil || server.Logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("expect a logger at warn level")
+	}
+	if server.AccessLog.Handler != server.Logger.Handler() {
+		t.Fatal("access log should use the configured logger")
+	}
+	if server.Tracer == nil || server.Tracer.Exporter == nil {
+		t.Fatal("expect a tracer")
+	}
+
+	server, err = NewServer(&Config{Listeners: cfg.Listeners, Services: cfg.Services})
+	if err != nil || server.Logger != nil || server.Tracer != nil {
+		t.Fatalf("expect no logger and tracer by default, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.Method+" "+r.Header.Get(RegistryHeader))
+		mu.Unlock()
+	}))
+	defer registry.Close()
+
+	// 上次异常退出残留的 socket 文件会被删除
+	sock := filepath.Join(t.TempDir(), "geerpc.sock")
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	cfg := &Config{
+		Listeners: []ListenerConfig{{Network: "unix", Addr: sock}},
+		Services:  []string{"Echo"},
+		Registry:  &RegistryConfig{URL: registry.URL},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	// 等待开始监听
+	client, err := service.XDial("unix@" + sock)
+	for deadline := time.Now().Add(time.Second); err != nil && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+		client, err = service.XDial("unix@" + sock)
+	}
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	var reply string
+	if err := client.Call("Echo.Echo", "hi", &reply); err != nil || reply != "hi" {
+		t.Fatalf("call failed: %q %v", reply, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expect clean shutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run did not return after cancel")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"POST unix@" + sock, "DELETE unix@" + sock}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("expect registry events %v, got %v", want, events)
+	}
+}
+
+func TestRun_UnknownPlugin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Services = []string{"Nope"}
+	if err := Run(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "unknown plugin") {
+		t.Fatalf("expect unknown plugin error, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// 配置文件为 JSON, 时间使用 time.ParseDuration 的格式, 例如:
+//
+//	{
+//		"listeners": [
+//			{"network": "tcp", "addr": ":9007"},
+//			{"network": "tcp", "addr": ":9443", "tls": {"cert_file": "server.pem", "key_file": "server.key"}},
+//			{"network": "unix", "addr": "/run/geerpc.sock"},
+//			{"network": "http", "addr": ":8080"}
+//		],
+//		"services": ["Foo"],
+//		"handle_timeout": "5s",
+//		"max_in_flight": 1000,
+//		"registry": {"url": "http://registry:9999/_geerpc_/registry", "interval": "30s"},
+//		"debug_addr": "127.0.0.1:6060",
+//		"log": {"level": "debug", "format": "json"},
+//		"trace": {"output": "stdout"},
+//		"shutdown_timeout": "15s"
+//	}
+//
+// http 监听地址不支持 tls: 注册到注册中心的地址是 ws://, 需要 TLS 时在前面放一个终止 TLS 的代理
+
+// Network 为 "http" 时在同一个端口上提供 WebSocket、REST 和 JSON-RPC 网关, 见 httpHandler
+const NetworkHTTP = "http"
+
+type Config struct {
+	Listeners []ListenerConfig `json:"listeners"`
+	// 启用的插件, 为空表示所有通过 Register 注册的插件
+	Services []string `json:"services"`
+
+	// 以下对应 service.Server 的同名字段
+	HandleTimeout     Duration `json:"handle_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+	MaxHeaderSize     int      `json:"max_header_size"`
+	MaxBodySize       int      `json:"max_body_size"`
+	MaxInFlight       int      `json:"max_in_flight"`
+	MaxConnInFlight   int      `json:"max_conn_in_flight"`
+	MaxQueue          int      `json:"max_queue"`
+	MaxAbandoned      int      `json:"max_abandoned"`
+
+	AccessLog *AccessLogConfig `json:"access_log"` // 为空表示不记录访问日志
+	Registry  *RegistryConfig  `json:"registry"`   // 为空表示不注册
+	Log       *LogConfig       `json:"log"`        // 服务端和访问日志的输出, 为空表示使用 slog.Default()
+	Trace     *TraceConfig     `json:"trace"`      // 为空表示不记录 span
+	// 提供 /metrics 和 /debug/pprof/ 的 HTTP 地址, 为空表示不提供
+	DebugAddr string `json:"debug_addr"`
+	// 收到信号后等待调用结束的时限, 超过后强制关闭连接, 0 表示 DefaultShutdownTimeout
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+const DefaultShutdownTimeout = 30 * time.Second
+
+type ListenerConfig struct {
+	Network string     `json:"network"` // tcp, tcp4, tcp6, unix 或者 http
+	Addr    string     `json:"addr"`
+	TLS     *TLSConfig `json:"tls"` // http 监听地址不支持
+	// 注册到注册中心的地址, 为空时使用 network@addr, 监听 ":port" 时需要设置
+	Advertise string `json:"advertise"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// 不为空时要求并验证客户端证书
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+type AccessLogConfig struct {
+	SampleEvery   int      `json:"sample_every"`
+	SlowThreshold Duration `json:"slow_threshold"`
+}
+
+// 日志写到标准错误
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn 或 error, 为空表示 info
+	Format string `json:"format"` // text 或 json, 为空表示 text
+}
+
+// 为每次调用创建 server span, 以 JSON 行的格式输出, 见 trace.JSONExporter
+type TraceConfig struct {
+	Output string `json:"output"` // stdout 或 stderr, 为空表示 stdout
+}
+
+// Duration 在 JSON 中写作 "10s", "500ms" 等
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig 只在 :9007 上监听 tcp
+func DefaultConfig() *Config {
+	return &Config{Listeners: []ListenerConfig{{Network: "tcp", Addr: ":9007"}}}
+}
+
+// LoadConfig 读取并检查配置文件, 未知的字段视为错误
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	cfg := new(Config)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("app: parse %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("app: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) Validate() error {
+	if len(cfg.Listeners) == 0 {
+		return errors.New("no listeners")
+	}
+	for i, l := range cfg.Listeners {
+		switch l.Network {
+		case "tcp", "tcp4", "tcp6", "unix", NetworkHTTP:
+		default:
+			return fmt.Errorf("listeners[%d]: unsupported network %q", i, l.Network)
+		}
+		if l.Addr == "" {
+			return fmt.Errorf("listeners[%d]: empty addr", i)
+		}
+		if l.TLS != nil && (l.TLS.CertFile == "" || l.TLS.KeyFile == "") {
+			return fmt.Errorf("listeners[%d]: tls needs cert_file and key_file", i)
+		}
+		// 注册的地址是 ws://, 还不支持 wss
+		if l.TLS != nil && l.Network == NetworkHTTP {
+			return fmt.Errorf("listeners[%d]: tls is not supported on http listeners", i)
+		}
+	}
+	if cfg.Registry != nil && cfg.Registry.URL == "" {
+		return errors.New("registry: empty url")
+	}
+	if cfg.Log != nil {
+		if _, err := cfg.Log.handler(); err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+	}
+	if cfg.Trace != nil {
+		if _, err := cfg.Trace.writer(); err != nil {
+			return fmt.Errorf("trace: %w", err)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) shutdownTimeout() time.Duration {
+	if cfg.ShutdownTimeout > 0 {
+		return time.Duration(cfg.ShutdownTimeout)
+	}
+	return DefaultShutdownTimeout
+}
+
+func (c *LogConfig) handler() (slog.Handler, error) {
+	var level slog.Level
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+			return nil, fmt.Errorf("unknown level %q", c.Level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.Format {
+	case "", "text":
+		return slog.NewTextHandler(os.Stderr, opts), nil
+	case "json":
+		return slog.NewJSONHandler(os.Stderr, opts), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", c.Format)
+}
+
+func (c *TraceConfig) writer() (io.Writer, error) {
+	switch c.Output {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return nil, fmt.Errorf("unknown output %q", c.Output)
+}
+
+func (c *TLSConfig) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"GeeRPC/service"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 插件: 服务所在的包在 init 中调用 Register, 自己的 main 包匿名导入这些包后调用 Main,
+// 就得到一个带有这些服务的服务端程序:
+//
+//	package foo
+//
+//	func init() {
+//		app.Register("Foo", func(s *service.Server) error { return s.Register(new(Foo)) })
+//	}
+//
+//	package main
+//
+//	import _ "example.com/foo"
+//
+//	func main() { app.Main() }
+//
+// 配置文件的 services 可以只启用其中的一部分
+
+// Plugin 在服务端开始监听之前注册服务, 也可以设置拦截器等
+type Plugin func(server *service.Server) error
+
+var (
+	pluginsMu sync.Mutex
+	plugins   = make(map[string]Plugin)
+)
+
+// Register 注册一个插件, 名字重复时 panic, 与 database/sql 的 Register 一致
+func Register(name string, p Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if p == nil {
+		panic("app: Register plugin is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic("app: Register called twice for plugin " + name)
+	}
+	plugins[name] = p
+}
+
+// Plugins 返回所有已注册插件的名字, 按名字排序
+func Plugins() []string {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 按名字顺序调用启用的插件, names 为空表示所有插件
+func installPlugins(server *service.Server, names []string) error {
+	if len(names) == 0 {
+		names = Plugins()
+	}
+	for _, name := range names {
+		pluginsMu.Lock()
+		p := plugins[name]
+		pluginsMu.Unlock()
+		if p == nil {
+			return fmt.Errorf("app: unknown plugin %q, registered: %v", name, Plugins())
+		}
+		if err := p(server); err != nil {
+			return fmt.Errorf("app: plugin %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// 注册中心: 按 Interval 向 URL 发送 POST 心跳, header X-Geerpc-Server 为本服务的地址 (network@addr),
+// 与 xclient 的地址格式一致; 注册中心在几个间隔内没有收到心跳时摘除该地址
+// 关闭时先发送同样 header 的 DELETE 注销, 再等待调用结束, 让客户端尽早停止选择这个节点
+
+const (
+	RegistryHeader          = "X-Geerpc-Server"
+	DefaultRegistryInterval = time.Minute
+)
+
+type RegistryConfig struct {
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"` // 心跳间隔, 0 表示 DefaultRegistryInterval
+}
+
+func (c *RegistryConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval)
+	}
+	return DefaultRegistryInterval
+}
+
+func sendRegistry(ctx context.Context, method, url, addr string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(RegistryHeader, addr)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("registry replied %s", resp.Status)
+	}
+	return nil
+}
+
+// 立即发送一次心跳, 之后按间隔发送, 直到 ctx 结束
+func heartbeat(ctx context.Context, c *RegistryConfig, addrs []string, logger *slog.Logger) {
+	ticker := time.NewTicker(c.interval())
+	defer ticker.Stop()
+	for {
+		for _, addr := range addrs {
+			if err := sendRegistry(ctx, http.MethodPost, c.URL, addr); err != nil && ctx.Err() == nil {
+				logger.Warn("app: registry heartbeat failed", "addr", addr, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 注销失败时只记录日志, 注册中心会在心跳超时后摘除
+func deregister(ctx context.Context, c *RegistryConfig, addrs []string, logger *slog.Logger) {
+	for _, addr := range addrs {
+		if err := sendRegistry(ctx, http.MethodDelete, c.URL, addr); err != nil {
+			logger.Warn("app: registry deregister failed", "addr", addr, "err", err)
+		}
+	}
+}
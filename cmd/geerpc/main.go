@@ -0,0 +1,282 @@
+// geerpc 是 GeeRPC 的命令行客户端, 使用 JSON 编码, 参数和回复都是 JSON:
+//
+//	geerpc call [flags] ADDR Service.Method [JSON]
+//	geerpc list [flags] ADDR [Service]
+//	geerpc describe [flags] ADDR Service[.Method]
+//
+// ADDR 为 host:port (tcp) 或者 network@addr, 例如 unix@/tmp/geerpc.sock, ws@ws://host:port/_geerpc_/ws
+// JSON 为 "-" 时从标准输入读取, 省略时使用参数的零值; list 和 describe 依赖服务端的 _Reflection 服务
+package main
+
+import (
+	"GeeRPC/codec"
+	"GeeRPC/service"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const usage = `usage:
+  geerpc call [flags] ADDR Service.Method [JSON]   call a method and print the reply as JSON
+  geerpc list [flags] ADDR [Service]               list services, or methods of a service
+  geerpc describe [flags] ADDR Service[.Method]    describe argument and reply types as JSON
+
+ADDR is host:port for tcp, or network@addr (unix@/tmp/geerpc.sock, ws@ws://host:port/_geerpc_/ws).
+JSON "-" reads the arguments from stdin; omitted means the zero value.
+Run "geerpc COMMAND -h" for flags.
+`
+
+// 用法错误的退出码, 调用失败为 1
+const exitUsage = 2
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmd, args := args[0], args[1:]
+	var (
+		f   func(ctx context.Context, c *conn, args []string) error
+		min int // ADDR 之后至少需要的参数个数
+		max int
+	)
+	switch cmd {
+	case "call":
+		min, max = 1, 2
+		f = func(ctx context.Context, c *conn, args []string) error {
+			return call(ctx, c, args, stdin, stdout)
+		}
+	case "list":
+		min, max = 0, 1
+		f = func(ctx context.Context, c *conn, args []string) error {
+			return list(ctx, c, args, stdout)
+		}
+	case "describe":
+		min, max = 1, 1
+		f = func(ctx context.Context, c *conn, args []string) error {
+			return describe(ctx, c, args[0], stdout)
+		}
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "geerpc: unknown command %q\n\n%s", cmd, usage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("geerpc "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts := newOptions(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	args = fs.Args()
+	if len(args) < 1+min || len(args) > 1+max {
+		fmt.Fprintf(stderr, "geerpc %s: wrong number of arguments\n\n%s", cmd, usage)
+		return exitUsage
+	}
+	// unix socket 没有主机名, 默认会用 socket 路径校验证书
+	if opts.tlsEnabled() && opts.serverName == "" && !opts.insecure && strings.HasPrefix(args[0], "unix@") {
+		fmt.Fprintf(stderr, "geerpc %s: -servername is required for TLS over unix sockets\n", cmd)
+		return exitUsage
+	}
+
+	client, err := opts.dial(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "geerpc: dial %s: %v\n", args[0], err)
+		return 1
+	}
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	if err := f(ctx, &conn{client, opts.metadata}, args[1:]); err != nil {
+		fmt.Fprintf(stderr, "geerpc: %s: %v\n", service.CodeOf(err), err)
+		return 1
+	}
+	return 0
+}
+
+type options struct {
+	timeout  time.Duration
+	metadata metadataFlag
+
+	tls        bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	insecure   bool
+}
+
+func newOptions(fs *flag.FlagSet) *options {
+	o := &options{metadata: make(metadataFlag)}
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "connect and call timeout, 0 means no limit")
+	fs.Var(o.metadata, "H", "request metadata as key=value, can be repeated")
+	fs.BoolVar(&o.tls, "tls", false, "use TLS")
+	fs.StringVar(&o.caFile, "cacert", "", "PEM file of CAs to verify the server with, implies -tls")
+	fs.StringVar(&o.certFile, "cert", "", "PEM client certificate for mutual TLS, implies -tls")
+	fs.StringVar(&o.keyFile, "key", "", "PEM private key of -cert")
+	fs.StringVar(&o.serverName, "servername", "", "server name to verify, defaults to the host of ADDR; required for TLS over unix@")
+	fs.BoolVar(&o.insecure, "insecure", false, "skip server certificate verification, implies -tls")
+	return o
+}
+
+func (o *options) dial(addr string) (*service.Client, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	opt := &service.Option{
+		CodecType:      codec.JsonType,
+		ConnectTimeout: o.timeout,
+		TLSConfig:      tlsConfig,
+	}
+	if !strings.Contains(addr, "@") {
+		addr = "tcp@" + addr
+	}
+	return service.XDial(addr, opt)
+}
+
+func (o *options) tlsEnabled() bool {
+	return o.tls || o.caFile != "" || o.certFile != "" || o.insecure || o.serverName != ""
+}
+
+// 没有任何 TLS 参数时返回 nil
+func (o *options) tlsConfig() (*tls.Config, error) {
+	if !o.tlsEnabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{ServerName: o.serverName, InsecureSkipVerify: o.insecure}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.caFile)
+		}
+	}
+	if o.certFile != "" || o.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// -H key=value, 键统一转为小写
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("expect key=value")
+	}
+	m[strings.ToLower(k)] = v
+	return nil
+}
+
+// conn 是一条连接和每次调用都带上的 metadata
+type conn struct {
+	client   *service.Client
+	metadata map[string]string
+}
+
+func (c *conn) call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return c.client.CallContext(ctx, serviceMethod, args, reply, service.WithMetadata(c.metadata))
+}
+
+func call(ctx context.Context, c *conn, args []string, stdin io.Reader, stdout io.Writer) error {
+	params := []byte("null")
+	if len(args) == 2 {
+		params = []byte(args[1])
+		if args[1] == "-" {
+			var err error
+			if params, err = io.ReadAll(stdin); err != nil {
+				return err
+			}
+		}
+	}
+	if !json.Valid(params) {
+		return service.Errorf(service.InvalidArgument, "arguments are not valid JSON")
+	}
+	var reply json.RawMessage
+	if err := c.call(ctx, args[0], json.RawMessage(params), &reply); err != nil {
+		return err
+	}
+	return printJSON(stdout, reply)
+}
+
+// 没有参数时列出服务名, 指定服务时列出 Service.Method
+func list(ctx context.Context, c *conn, args []string, stdout io.Writer) error {
+	var req service.ListArgs
+	if len(args) == 1 {
+		req.Service = args[0]
+	}
+	var reply service.ListReply
+	if err := c.call(ctx, service.ReflectionList, req, &reply); err != nil {
+		return err
+	}
+	for _, svc := range reply.Services {
+		if req.Service == "" {
+			fmt.Fprintln(stdout, svc.Name)
+			continue
+		}
+		for _, m := range svc.Methods {
+			fmt.Fprintln(stdout, svc.Name+"."+m)
+		}
+	}
+	return nil
+}
+
+func describe(ctx context.Context, c *conn, name string, stdout io.Writer) error {
+	var reply service.ServiceDesc
+	if err := c.call(ctx, service.ReflectionDescribe, service.DescribeArgs{Name: name}, &reply); err != nil {
+		return err
+	}
+	b, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return printJSON(stdout, b)
+}
+
+func printJSON(w io.Writer, raw []byte) error {
+	var b bytes.Buffer
+	if err := json.Indent(&b, raw, "", "  "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := b.WriteTo(w)
+	return err
+}
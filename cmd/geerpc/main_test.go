@@ -0,0 +1,116 @@
+package main
+
+import (
+	"GeeRPC/service"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Calc int
+
+func (Calc) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// 回复请求中 args 对应的 metadata
+func (Calc) Meta(ctx context.Context, key string, reply *string) error {
+	md, _ := service.MetadataFromContext(ctx)
+	*reply = md[key]
+	return nil
+}
+
+// 启动测试用的服务器并注册 rcvrs, 与 service 包测试中的 startTestServer 相同
+func startTestServer(tb testing.TB, network string, server *service.Server, rcvrs ...interface{}) string {
+	tb.Helper()
+	l, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen %s failed: %v", network, err)
+	}
+	for _, rcvr := range rcvrs {
+		if err := server.Register(rcvr); err != nil {
+			tb.Fatalf("register failed: %v", err)
+		}
+	}
+	go server.Accept(l)
+	tb.Cleanup(func() { _ = l.Close() })
+	return l.Addr().String()
+}
+
+func runCmd(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRun(t *testing.T) {
+	addr := startTestServer(t, "tcp", service.NewServer(), new(Calc))
+
+	code, out, errOut := runCmd("", "call", addr, "Calc.Sum", `{"Num1": 1, "Num2": 2}`)
+	if code != 0 || out != "3\n" {
+		t.Fatalf("call: expect 3, got %d %q %q", code, out, errOut)
+	}
+	code, out, errOut = runCmd(`{"Num1": 3, "Num2": 4}`, "call", "tcp@"+addr, "Calc.Sum", "-")
+	if code != 0 || out != "7\n" {
+		t.Fatalf("call from stdin: expect 7, got %d %q %q", code, out, errOut)
+	}
+	code, out, errOut = runCmd("", "call", "-H", "X-User=alice", addr, "Calc.Meta", `"x-user"`)
+	if code != 0 || out != "\"alice\"\n" {
+		t.Fatalf("call with metadata: expect alice, got %d %q %q", code, out, errOut)
+	}
+	code, _, errOut = runCmd("", "call", addr, "Calc.Missing")
+	if code != 1 || !strings.Contains(errOut, "Unimplemented") {
+		t.Fatalf("call missing method: expect Unimplemented, got %d %q", code, errOut)
+	}
+
+	code, out, errOut = runCmd("", "list", addr)
+	if code != 0 || !strings.Contains(out, "Calc\n") {
+		t.Fatalf("list: got %d %q %q", code, out, errOut)
+	}
+	code, out, errOut = runCmd("", "list", addr, "Calc")
+	if code != 0 || out != "Calc.Meta\nCalc.Sum\n" {
+		t.Fatalf("list Calc: got %d %q %q", code, out, errOut)
+	}
+
+	code, out, errOut = runCmd("", "describe", addr, "Calc.Sum")
+	if code != 0 {
+		t.Fatalf("describe: got %d %q", code, errOut)
+	}
+	var desc service.ServiceDesc
+	if err := json.Unmarshal([]byte(out), &desc); err != nil {
+		t.Fatalf("describe: invalid JSON %q: %v", out, err)
+	}
+	if desc.Name != "Calc" || len(desc.Methods) != 1 || len(desc.Methods[0].Arg.Fields) != 2 {
+		t.Fatalf("describe: unexpected desc %+v", desc)
+	}
+}
+
+func TestRun_BadInput(t *testing.T) {
+	addr := startTestServer(t, "tcp", service.NewServer(), new(Calc))
+	for _, c := range []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"call", addr, "Calc.Sum", `{"Num1": `}, 1, "InvalidArgument"},
+		{[]string{"call", addr}, exitUsage, "wrong number of arguments"},
+		{[]string{"call", addr, "Calc.Sum", "{}", "extra"}, exitUsage, "wrong number of arguments"},
+		{[]string{"list", addr, "Calc", "extra"}, exitUsage, "wrong number of arguments"},
+		{[]string{"describe", addr}, exitUsage, "wrong number of arguments"},
+		{[]string{"call", "-H", "novalue", addr, "Calc.Sum"}, exitUsage, "expect key=value"},
+		{[]string{"call", "-tls", "unix@/tmp/geerpc.sock", "Calc.Sum"}, exitUsage, "-servername is required"},
+		{[]string{"nope"}, exitUsage, "unknown command"},
+		{nil, exitUsage, "usage:"},
+	} {
+		code, _, errOut := runCmd("", c.args...)
+		if code != c.code || !strings.Contains(errOut, c.want) {
+			t.Fatalf("%v: expect %d %q, got %d %q", c.args, c.code, c.want, code, errOut)
+		}
+	}
+}
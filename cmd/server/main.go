@@ -1,27 +1,23 @@
+// server 是带有 foo.Foo 服务的示例服务端:
+//
+//	server -config geerpc.json
+//
+// 不指定配置文件时在 :9007 上监听 tcp, 配置文件的格式见 app.Config
+// 其他服务端程序可以用同样的方式注册自己的插件, 然后调用 app.Main
 package main
 
 import (
+	"GeeRPC/app"
 	"GeeRPC/foo"
 	"GeeRPC/service"
-	"log"
-	"net"
 )
 
-func startServer() {
-	l, err := net.Listen("tcp", ":9007")
-	if err != nil {
-		log.Fatal("network error!:", err)
-	}
-	log.Println("start rpc server!", l.Addr())
-
-	var fooservice foo.Foo
-	if err := service.Register(&fooservice); err != nil {
-		log.Fatal("register error: ", err)
-	}
-
-	service.Accept(l)
+func init() {
+	app.Register("Foo", func(server *service.Server) error {
+		return server.Register(new(foo.Foo))
+	})
 }
 
 func main() {
-	startServer()
+	app.Main()
 }
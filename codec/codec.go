@@ -1,12 +1,22 @@
 // 消息编解码
 package codec
 
-import "io"
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
 
 type Header struct {
 	ServiceMethod string // "Service.Method"
 	Seq           uint64 // 请求ID
 	Err           string
+	Code          uint32 // 错误码, 取值见 service.Code, 0 表示成功
+	Compressed    bool   // body 是否经过压缩, 压缩算法在 Option 中协商
+	Priority      uint8  // 请求的优先级, 越大越优先, 0 表示默认, 取值见 service.Priority
+	// 附加的键值对, 例如服务端回复的 retry-after, 键统一用小写
+	Metadata map[string]string
 }
 
 // 编解码的接口
@@ -14,7 +24,13 @@ type Codec interface {
 	io.Closer
 	ReadBody(interface{}) error // 解码后存在空接口类型中
 	ReadHeader(*Header) error
-	Write(*Header, interface{}) error // encodes and writes a message, consisting of a header and a body, to the underlying connection
+	Write(*Header, interface{}) error // encodes and writes a message, consisting of a header and a body, to the underlying connection; safe for concurrent use
+}
+
+// Sizer 由能报告消息在线上字节数的 Codec 实现, 内置的编码方式都实现了它, 用于访问日志
+type Sizer interface {
+	ReadSize() int                                // 最近读到的消息的字节数, 只能在读协程中调用
+	WriteSized(*Header, interface{}) (int, error) // 与 Write 相同, 同时返回写出的字节数
 }
 
 // 抽象出构造函数
@@ -22,16 +38,80 @@ type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
 
-// 两种编码方式
+// 内置的编码方式
 const (
-	GobType  Type = "application/gob"
-	JsonType Type = "application/json"
+	GobType      Type = "application/gob"
+	JsonType     Type = "application/json"
+	MsgpackType  Type = "application/msgpack"
+	ProtobufType Type = "application/protobuf"
 )
 
-// 根据type获得构造函数
+// 已注册的编码方式, 第三方格式通过 Register 加入
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[Type]NewCodecFunc)
+)
+
+// Register 注册一种编码方式, 可以在任意 package 的 init 中调用
+// 类型重复注册时返回错误, 不会覆盖已有的实现
+func Register(t Type, f NewCodecFunc) error {
+	if t == "" || f == nil {
+		return errors.New("rpc codec: register with empty type or nil constructor")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, dup := codecs[t]; dup {
+		return errors.New("rpc codec: codec already registered: " + string(t))
+	}
+	codecs[t] = f
+	return nil
+}
+
+// 注销一种编码方式, 只用于测试
+func unregister(t Type) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	delete(codecs, t)
+}
+
+// Lookup 根据type获得构造函数, 未注册时返回 nil
+func Lookup(t Type) NewCodecFunc {
+	codecsMu.RLock()
+	f := codecs[t]
+	codecsMu.RUnlock()
+	if f == nil {
+		// 兼容直接写入 NewCodecFuncMap 的旧代码
+		f = NewCodecFuncMap[t]
+	}
+	return f
+}
+
+// NewCodecFuncMap 根据type获得构造函数, 包含内置的编码方式
+//
+// Deprecated: 使用 Register 和 Lookup; 只能在 init 中写入, 写入的编码方式可以被 Lookup 找到,
+// 但不会出现在 Types 中, 服务端协商时不会主动提供
 var NewCodecFuncMap map[Type]NewCodecFunc
 
+// Types 返回所有已注册的编码方式, 按名字排序
+func Types() []Type {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	types := make([]Type, 0, len(codecs))
+	for t := range codecs {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
 func init() {
-	NewCodecFuncMap = make(map[Type]NewCodecFunc)
-	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap = map[Type]NewCodecFunc{
+		GobType:      NewGobCodec,
+		JsonType:     NewJsonCodec,
+		MsgpackType:  NewMsgpackCodec,
+		ProtobufType: NewProtobufCodec,
+	}
+	for t, f := range NewCodecFuncMap {
+		_ = Register(t, f)
+	}
 }
@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	if err := Register(GobType, NewGobCodec); err == nil {
+		t.Fatal("expect an error when registering a duplicate codec")
+	}
+	const custom Type = "application/x-test"
+	if err := Register(custom, NewJsonCodec); err != nil {
+		t.Fatal(err)
+	}
+	// 注册表是全局的, 测试结束后移除, 不影响其他测试和 Types
+	t.Cleanup(func() { unregister(custom) })
+	if Lookup(custom) == nil || Lookup("application/unknown") != nil {
+		t.Fatal("lookup returned unexpected constructor")
+	}
+	for _, typ := range Types() {
+		if typ == custom {
+			return
+		}
+	}
+	t.Fatalf("expect %s in Types, got %v", custom, Types())
+}
+
+func TestFrameCodec_Size(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewJsonCodec(a), NewJsonCodec(b)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+
+	written := make(chan int, 1)
+	go func() {
+		n, err := w.(Sizer).WriteSized(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, map[string]int{"Num1": 1})
+		if err != nil {
+			n = -1
+		}
+		written <- n
+	}()
+	var h Header
+	var body map[string]int
+	if err := r.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	n := <-written
+	if got := r.(Sizer).ReadSize(); n <= 2*frameLenSize || got != n {
+		t.Fatalf("expect read size to equal written size %d, got %d", n, got)
+	}
+}
+
+// flush 失败时 Write 返回错误, 之后的写入也失败
+func TestFrameCodec_WriteError(t *testing.T) {
+	a, b := net.Pipe()
+	_ = b.Close()
+	w := NewJsonCodec(a)
+	defer func() { _ = w.Close() }()
+	if err := w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1); err == nil {
+		t.Fatal("expect write to fail when the peer is closed")
+	}
+	if err := w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2}, 2); err == nil {
+		t.Fatal("expect later writes to fail")
+	}
+}
+
+// 写到 TCP 连接, 对端只读不解码
+func benchmarkWrite(b *testing.B, parallel bool) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	cc := NewJsonCodec(conn)
+	defer func() { _ = cc.Close() }()
+	body := map[string]int{"Num1": 1, "Num2": 2}
+	b.ResetTimer()
+	if !parallel {
+		for i := 0; i < b.N; i++ {
+			if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}, body); err != nil {
+				b.Fatal(err)
+			}
+		}
+		return
+	}
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := cc.Write(&Header{ServiceMethod: "Foo.Sum"}, body); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkFrameCodec_Write(b *testing.B)         { benchmarkWrite(b, false) }
+func BenchmarkFrameCodec_WriteParallel(b *testing.B) { benchmarkWrite(b, true) }
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compressor 压缩和解压 body, 需要可以并发使用
+type Compressor interface {
+	Compress(p []byte) ([]byte, error)
+	// Decompress 解压后的大小超过 max 时返回 ErrBodyTooLarge
+	Decompress(p []byte, max int) ([]byte, error)
+}
+
+// 内置的压缩算法
+const (
+	Gzip   = "gzip"
+	Snappy = "snappy"
+)
+
+// 小于该大小的 body 不压缩
+const DefaultCompressThreshold = 1024
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[string]Compressor)
+)
+
+// RegisterCompressor 注册一种压缩算法, 名字重复时返回错误
+func RegisterCompressor(name string, c Compressor) error {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, dup := compressors[name]; dup {
+		return errors.New("rpc codec: compressor already registered: " + name)
+	}
+	compressors[name] = c
+	return nil
+}
+
+// GetCompressor 根据名字获取压缩算法, 不存在时返回 nil
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+func init() {
+	_ = RegisterCompressor(Gzip, &gzipCompressor{})
+	_ = RegisterCompressor(Snappy, snappyCompressor{})
+}
+
+// gzip 压缩率高, 但比较慢
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) Compress(p []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw, _ := c.writers.Get().(*gzip.Writer)
+	if zw == nil {
+		zw = gzip.NewWriter(&b)
+	} else {
+		zw.Reset(&b)
+	}
+	defer c.writers.Put(zw)
+	if _, err := zw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(p []byte, max int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	// 多读一个字节用来判断是否超长, 防止压缩炸弹
+	out, err := io.ReadAll(io.LimitReader(zr, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return out, nil
+}
+
+// snappy 压缩率一般, 但速度快得多
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(p []byte) ([]byte, error) {
+	return snappy.Encode(nil, p), nil
+}
+
+func (snappyCompressor) Decompress(p []byte, max int) ([]byte, error) {
+	n, err := snappy.DecodedLen(p)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, ErrBodyTooLarge
+	}
+	return snappy.Decode(nil, p)
+}
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrameCodec_Compress(t *testing.T) {
+	for _, name := range []string{Gzip, Snappy} {
+		t.Run(name, func(t *testing.T) {
+			a, b := net.Pipe()
+			w, r := NewGobCodec(a), NewGobCodec(b)
+			defer func() { _ = w.Close(); _ = r.Close() }()
+			cfg := Config{Compressor: name, CompressThreshold: 64}
+			w.(Configurable).Configure(cfg)
+			r.(Configurable).Configure(cfg)
+
+			big := strings.Repeat("geerpc ", 1024)
+			go func() {
+				_ = w.Write(&Header{Seq: 1}, big)
+				_ = w.Write(&Header{Seq: 2}, "small")
+			}()
+			for _, want := range []struct {
+				body       string
+				compressed bool
+			}{{big, true}, {"small", false}} {
+				var h Header
+				var got string
+				if err := r.ReadHeader(&h); err != nil {
+					t.Fatal(err)
+				}
+				if err := r.ReadBody(&got); err != nil {
+					t.Fatal(err)
+				}
+				if h.Compressed != want.compressed || got != want.body {
+					t.Fatalf("seq %d: compressed=%v, body match=%v", h.Seq, h.Compressed, got == want.body)
+				}
+			}
+		})
+	}
+}
+
+// 解压后的大小同样受 MaxBodySize 限制
+func TestCompressor_DecompressLimit(t *testing.T) {
+	for _, name := range []string{Gzip, Snappy} {
+		c := GetCompressor(name)
+		p, err := c.Compress(make([]byte, 1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Decompress(p, 1024); err != ErrBodyTooLarge {
+			t.Fatalf("%s: expect ErrBodyTooLarge, got %v", name, err)
+		}
+	}
+}
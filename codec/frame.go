@@ -0,0 +1,305 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 线上的每个 header 和 body 都单独成帧:
+// | length uint32 (大端) | payload [length]byte |
+// 读取时先拿到长度, 超过限制的帧不会分配内存
+const frameLenSize = 4
+
+const (
+	DefaultMaxHeaderSize = 64 << 10 // 64KB
+	DefaultMaxBodySize   = 4 << 20  // 4MB
+
+	// body 帧超过限制的这个倍数时不再丢弃, 直接断开连接
+	maxDiscardFactor = 2
+)
+
+var (
+	// header 超长说明对端不遵守分帧协议, 只能断开连接
+	ErrHeaderTooLarge = errors.New("rpc codec: header exceeds size limit")
+	// body 超长时该帧会被丢弃, 连接仍然可用; 超过限制太多时连接会被关闭
+	ErrBodyTooLarge = errors.New("rpc codec: body exceeds size limit")
+	// 帧内容与解码结果不一致
+	ErrBadFrame = errors.New("rpc codec: malformed frame")
+)
+
+// 连接级别的编解码配置
+type Config struct {
+	MaxHeaderSize     int    // header 帧最大字节数, 0 表示 DefaultMaxHeaderSize
+	MaxBodySize       int    // body 帧 (解压后) 最大字节数, 0 表示 DefaultMaxBodySize
+	Compressor        string // 协商好的压缩算法, 空表示不压缩
+	CompressThreshold int    // 达到该大小的 body 才压缩, 0 表示 DefaultCompressThreshold
+}
+
+func (cfg Config) maxHeaderSize() int {
+	if cfg.MaxHeaderSize > 0 {
+		return cfg.MaxHeaderSize
+	}
+	return DefaultMaxHeaderSize
+}
+
+func (cfg Config) maxBodySize() int {
+	if cfg.MaxBodySize > 0 {
+		return cfg.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+func (cfg Config) compressThreshold() int {
+	if cfg.CompressThreshold > 0 {
+		return cfg.CompressThreshold
+	}
+	return DefaultCompressThreshold
+}
+
+// Configurable 由支持连接级配置的 Codec 实现, 需要在第一次读写之前调用
+type Configurable interface {
+	Configure(cfg Config)
+}
+
+// WriteFrame 写入一个带长度前缀的帧
+func WriteFrame(w io.Writer, p []byte) error {
+	var l [frameLenSize]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(p)))
+	if _, err := w.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// ReadFrame 读取一个带长度前缀的帧, 长度超过 max 时返回 ErrHeaderTooLarge 且不读取内容
+// max <= 0 表示 DefaultMaxHeaderSize
+func ReadFrame(r io.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		max = DefaultMaxHeaderSize
+	}
+	n, err := readFrameLen(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, ErrHeaderTooLarge
+	}
+	return readFramePayload(r, n)
+}
+
+func readFrameLen(r io.Reader) (int, error) {
+	var l [frameLenSize]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(l[:])), nil
+}
+
+func readFramePayload(r io.Reader, n int) ([]byte, error) {
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// 具体格式只负责 header/body 与字节之间的转换, 分帧、限长、批量写由 frameCodec 完成
+type marshaler interface {
+	marshalHeader(*Header) ([]byte, error)
+	unmarshalHeader([]byte, *Header) error
+	marshalBody(interface{}) ([]byte, error)
+	unmarshalBody([]byte, interface{}) error
+}
+
+// frameCodec 实现了 Codec 中与具体格式无关的部分
+type frameCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  *bufio.Writer
+	w    *batchWriter
+	m    marshaler
+	cfg  Config
+	comp Compressor // cfg.Compressor 对应的压缩算法
+
+	varint bool // 长度前缀为 varint, 与 protobuf 的 length-delimited 格式 (protodelim) 一致, 见 ProtobufCodec
+
+	compressed bool // 最近读到的 header 的 body 是否经过压缩, 只在读协程中使用
+	readSize   int  // 最近读到的消息 header 和 body 帧的字节数, 只在读协程中使用
+}
+
+func newFrameCodec(conn io.ReadWriteCloser, m marshaler) *frameCodec {
+	c := &frameCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+		m:    m,
+	}
+	c.w = newBatchWriter(conn, c.buf, c.encode)
+	return c
+}
+
+func (c *frameCodec) Configure(cfg Config) {
+	c.cfg = cfg
+	c.comp = nil
+	if cfg.Compressor != "" {
+		c.comp = GetCompressor(cfg.Compressor)
+	}
+}
+
+// 读取帧的长度, 同时返回长度前缀的字节数
+func (c *frameCodec) readLen() (n, size int, err error) {
+	if !c.varint {
+		n, err = readFrameLen(c.r)
+		return n, frameLenSize, err
+	}
+	v, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if v > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("%w: frame length %d overflows", ErrBadFrame, v)
+	}
+	n = int(v)
+	return n, uvarintSize(v), nil
+}
+
+// 写入一个帧, 返回写入的字节数
+func (c *frameCodec) writeFrame(p []byte) (int, error) {
+	if !c.varint {
+		return frameLenSize + len(p), WriteFrame(c.buf, p)
+	}
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(p)))
+	if _, err := c.buf.Write(l[:n]); err != nil {
+		return 0, err
+	}
+	_, err := c.buf.Write(p)
+	return n + len(p), err
+}
+
+func uvarintSize(v uint64) int {
+	var l [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(l[:], v)
+}
+
+func (c *frameCodec) ReadHeader(h *Header) error {
+	c.readSize = 0
+	n, size, err := c.readLen()
+	if err != nil {
+		return err
+	}
+	if n > c.cfg.maxHeaderSize() {
+		return ErrHeaderTooLarge
+	}
+	p, err := readFramePayload(c.r, n)
+	if err != nil {
+		return err
+	}
+	c.readSize = size + len(p)
+	if err := c.m.unmarshalHeader(p, h); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadFrame, err)
+	}
+	c.compressed = h.Compressed
+	return nil
+}
+
+// ReadBody 读取紧跟在 header 后面的 body, body 为 nil 时直接丢弃
+func (c *frameCodec) ReadBody(body interface{}) error {
+	n, size, err := c.readLen()
+	if err != nil {
+		return err
+	}
+	c.readSize += size + n
+	if n > c.cfg.maxBodySize() {
+		// 超出不多时丢弃这一帧, 连接仍然可用; 超出太多时读完它不值得 (长度最大 4GB), 直接断开连接
+		if n > maxDiscardFactor*c.cfg.maxBodySize() {
+			_ = c.conn.Close()
+			return fmt.Errorf("%w: %d bytes, connection closed", ErrBodyTooLarge, n)
+		}
+		if _, err := c.r.Discard(n); err != nil {
+			return err
+		}
+		return ErrBodyTooLarge
+	}
+	if body == nil {
+		_, err := c.r.Discard(n)
+		return err
+	}
+	p, err := readFramePayload(c.r, n)
+	if err != nil {
+		return err
+	}
+	if c.compressed {
+		if c.comp == nil {
+			return fmt.Errorf("%w: compressed body without negotiated compressor", ErrBadFrame)
+		}
+		if p, err = c.comp.Decompress(p, c.cfg.maxBodySize()); err != nil {
+			return err
+		}
+	}
+	return c.m.unmarshalBody(p, body)
+}
+
+// Write 可以并发调用: 在调用方的协程中编码, 并发写入的消息合并 flush, 见 batchWriter
+func (c *frameCodec) Write(h *Header, body interface{}) error {
+	_, err := c.w.write(h, body)
+	return err
+}
+
+// WriteSized 与 Write 相同, 同时返回消息在线上的字节数
+func (c *frameCodec) WriteSized(h *Header, body interface{}) (int, error) {
+	return c.w.write(h, body)
+}
+
+// ReadSize 返回最近读到的消息在线上的字节数, 包括 header 和已读取 (或丢弃) 的 body, 只能在读协程中调用
+func (c *frameCodec) ReadSize() int {
+	return c.readSize
+}
+
+// 持有写锁时把一条消息编码进 buf, 返回写入的字节数
+// body 先编码并检查大小, 超长时不写任何数据, 连接仍然可用; 达到阈值的 body 会被压缩
+func (c *frameCodec) encode(h *Header, body interface{}) (int, error) {
+	b, err := c.m.marshalBody(body)
+	if err != nil {
+		return 0, &encodeError{err}
+	}
+	if len(b) > c.cfg.maxBodySize() {
+		return 0, &encodeError{ErrBodyTooLarge}
+	}
+	// 调用方的 header 可能被复用, 压缩标记写在副本上
+	hdr := *h
+	hdr.Compressed = false
+	if c.comp != nil && len(b) >= c.cfg.compressThreshold() {
+		zb, err := c.comp.Compress(b)
+		if err != nil {
+			return 0, &encodeError{err}
+		}
+		// 压缩后反而更大的就直接发送原文
+		if len(zb) < len(b) {
+			b, hdr.Compressed = zb, true
+		}
+	}
+	hb, err := c.m.marshalHeader(&hdr)
+	if err != nil {
+		return 0, err
+	}
+	hn, err := c.writeFrame(hb)
+	if err != nil {
+		return 0, err
+	}
+	bn, err := c.writeFrame(b)
+	return hn + bn, err
+}
+
+func (c *frameCodec) Close() error {
+	c.w.close()
+	return c.conn.Close()
+}
@@ -1,17 +1,30 @@
 package codec
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
-	"log"
 )
 
+// GobCodec 的 header 和 body 各自使用一个贯穿整个连接的 gob 流, 类型定义只在第一次用到时发送
+// body 的类型定义不放在 body 帧里, 而是跟在下一个 header 帧的 header 之后:
+//
+//	| header 帧: header 的 gob 消息, body 流新增的类型定义 | body 帧: body 的值消息 |
+//
+// 这样 body 帧只有值, 超长、ReadBody(nil) 丢弃或者压缩都不会让对端错过类型定义;
+// header 帧不会被丢弃 (超长时断开连接)
 type GobCodec struct {
-	conn io.ReadWriteCloser // conn 是由构建函数传入，通常是通过 TCP 或者 Unix 建立 socket 时得到的链接实例
-	buf  *bufio.Writer      // buf 是为了防止阻塞而创建的带缓冲的 Writer
-	dec  *gob.Decoder
-	enc  *gob.Encoder
+	*frameCodec
+	hdec *gob.Decoder
+	henc *gob.Encoder
+	hr   bytes.Buffer // 当前读到的 header 帧
+	hw   bytes.Buffer // 正在编码的 header 帧, 持有写锁时使用
+
+	bdec *gob.Decoder
+	benc *gob.Encoder
+	br   bytes.Buffer // body 流的输入: header 帧带来的类型定义和 body 帧的值, 只在读协程中使用
+	bw   bytes.Buffer // 正在编码的 body, 持有写锁时使用
+	defs []byte       // body 流中还没有发出去的类型定义, 随下一个 header 帧发送
 }
 
 // 确保 GobCodec 结构体实现了 Codec 接口
@@ -19,43 +32,108 @@ var _ Codec = (*GobCodec)(nil) // 将 nil 转换为 *GobCodec 类型的指针。
 
 // gob的构造函数
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
-	return &GobCodec{
-		conn: conn,
-		buf:  buf,
-		// 解码(读)可以直接进行, 编码(写)需要用buf来减少对conn的操作
-		dec: gob.NewDecoder(conn),
-		enc: gob.NewEncoder(buf),
+	c := &GobCodec{}
+	c.hdec = gob.NewDecoder(&c.hr)
+	c.henc = gob.NewEncoder(&c.hw)
+	c.bdec = gob.NewDecoder(&c.br)
+	c.benc = gob.NewEncoder(&c.bw)
+	c.frameCodec = newFrameCodec(conn, c)
+	return c
+}
+
+// 下面实现Gob的marshaler接口
+func (c *GobCodec) marshalHeader(h *Header) ([]byte, error) {
+	c.hw.Reset()
+	if err := c.henc.Encode(h); err != nil {
+		return nil, err
 	}
+	c.hw.Write(c.defs)
+	c.defs = c.defs[:0]
+	return c.hw.Bytes(), nil
 }
 
-// 下面实现Gob的Codec接口
-func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+func (c *GobCodec) unmarshalHeader(p []byte, h *Header) error {
+	c.hr.Reset()
+	c.hr.Write(p)
+	if err := c.hdec.Decode(h); err != nil {
+		return err
+	}
+	// header 之后是 body 流的类型定义, 交给 body 的解码器
+	if c.hr.Len() != 0 {
+		if _, value, ok := splitGobTypeDefs(c.hr.Bytes()); !ok || len(value) != 0 {
+			return ErrBadFrame
+		}
+		c.br.Write(c.hr.Bytes())
+	}
+	return nil
 }
 
-func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
+// 编码器认为类型定义已经发出, body 即使最终没有写出 (超长等), 类型定义也会随下一个 header 发送
+func (c *GobCodec) marshalBody(body interface{}) ([]byte, error) {
+	c.bw.Reset()
+	err := c.benc.Encode(body)
+	// 编码失败之前可能已经写出了一部分类型定义
+	defs, value, ok := splitGobTypeDefs(c.bw.Bytes())
+	c.defs = append(c.defs, defs...)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(value) == 0 {
+		return nil, ErrBadFrame
+	}
+	return value, nil
 }
 
-func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
-	defer func() {
-		_ = c.buf.Flush()
-		if err != nil {
-			_ = c.Close()
+func (c *GobCodec) unmarshalBody(p []byte, body interface{}) error {
+	c.br.Write(p)
+	err := c.bdec.Decode(body)
+	if c.br.Len() != 0 {
+		// 一帧恰好是一个值, 多出来的数据说明流已经错乱
+		c.br.Reset()
+		if err == nil {
+			err = ErrBadFrame
 		}
-	}()
-	if err := c.enc.Encode(h); err != nil {
-		log.Println("rpc codec: gob error encoding header: ", err)
-		return err
 	}
-	if err := c.enc.Encode(body); err != nil {
-		log.Println("rpc codec: gob error encoding body: ", err)
-		return err
+	return err
+}
+
+// splitGobTypeDefs 把一段 gob 流拆成开头的类型定义消息和之后的值消息, 没有值消息时 value 为空
+// gob 消息格式为 | count uint | typeId int | ... |, typeId 为负数的是类型定义
+func splitGobTypeDefs(p []byte) (typeDefs, value []byte, ok bool) {
+	off := 0
+	for off < len(p) {
+		n, w := gobUint(p[off:])
+		if w == 0 || n == 0 || uint64(len(p)-off-w) < n {
+			return nil, nil, false
+		}
+		id, idw := gobUint(p[off+w:])
+		if idw == 0 {
+			return nil, nil, false
+		}
+		// gob 的 int 编码: 最低位为 1 表示负数
+		if id&1 == 0 {
+			return p[:off], p[off:], true
+		}
+		off += w + int(n)
 	}
-	return nil
+	return p, nil, true
 }
 
-func (c *GobCodec) Close() error {
-	return c.conn.Close()
+// gobUint 解码 gob 的 uint, 小于 128 的值占一个字节, 否则第一个字节是负的字节数, 后面跟大端表示
+func gobUint(p []byte) (x uint64, width int) {
+	if len(p) == 0 {
+		return 0, 0
+	}
+	b := p[0]
+	if b < 0x80 {
+		return uint64(b), 1
+	}
+	n := -int(int8(b))
+	if n > 8 || len(p) < n+1 {
+		return 0, 0
+	}
+	for _, c := range p[1 : n+1] {
+		x = x<<8 | uint64(c)
+	}
+	return x, n + 1
 }
@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type testArgs struct {
+	Num1, Num2 int
+	Name       string
+}
+
+type bigArgs struct {
+	Data []byte
+	Name string
+}
+
+// 类型定义只在第一次用到时发送, 不同类型交替出现时也能正确解码
+func TestGobCodec_TypeDefsOnce(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewGobCodec(a).(*GobCodec), NewGobCodec(b).(*GobCodec)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+
+	bodies := []interface{}{
+		testArgs{1, 2, "a"},
+		testArgs{3, 4, "b"},
+		"plain string",
+		testArgs{5, 6, "c"},
+	}
+	sizes := make(chan int, len(bodies))
+	go func() {
+		for i, body := range bodies {
+			n, err := w.WriteSized(&Header{ServiceMethod: "T.M", Seq: uint64(i)}, body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sizes <- n
+		}
+	}()
+	for i, want := range bodies {
+		var h Header
+		if err := r.ReadHeader(&h); err != nil || h.Seq != uint64(i) {
+			t.Fatalf("read header %d: %v, %+v", i, err, h)
+		}
+		switch want := want.(type) {
+		case testArgs:
+			var got testArgs
+			if err := r.ReadBody(&got); err != nil || got != want {
+				t.Fatalf("read body %d: %v, got %+v want %+v", i, err, got, want)
+			}
+		case string:
+			var got string
+			if err := r.ReadBody(&got); err != nil || got != want {
+				t.Fatalf("read body %d: %v, got %q want %q", i, err, got, want)
+			}
+		}
+	}
+	first, second := <-sizes, <-sizes
+	if second >= first {
+		t.Fatalf("expect type definitions to be sent once, got %d then %d bytes", first, second)
+	}
+}
+
+// 写端因为超长没有发出的 body, 它的类型定义仍然随下一个 header 发送
+func TestGobCodec_DroppedBodyKeepsTypeDefs(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewGobCodec(a).(*GobCodec), NewGobCodec(b).(*GobCodec)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+	w.Configure(Config{MaxBodySize: 64})
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- w.Write(&Header{Seq: 1}, bigArgs{Data: make([]byte, 1024)})
+		errc <- w.Write(&Header{Seq: 2}, bigArgs{Name: "ok"})
+	}()
+	var h Header
+	var got bigArgs
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("read header: %v, %+v", err, h)
+	}
+	if err := r.ReadBody(&got); err != nil || got.Name != "ok" {
+		t.Fatalf("read body: %v, %+v", err, got)
+	}
+	if err := <-errc; err != ErrBodyTooLarge {
+		t.Fatalf("expect ErrBodyTooLarge, got %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGobCodec_BodyTooLarge(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewGobCodec(a).(*GobCodec), NewGobCodec(b).(*GobCodec)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+	r.Configure(Config{MaxBodySize: 64})
+
+	go func() {
+		_ = w.Write(&Header{Seq: 1}, bigArgs{Data: make([]byte, 100)})
+		_ = w.Write(&Header{Seq: 2}, bigArgs{Name: "ok"})
+	}()
+	var h Header
+	var got bigArgs
+	if err := r.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadBody(&got); err != ErrBodyTooLarge {
+		t.Fatalf("expect ErrBodyTooLarge, got %v", err)
+	}
+	// 超长的帧被丢弃后, 后续消息可以正常读取, 第一帧带来的类型定义也没有丢
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("read header after oversized body: %v", err)
+	}
+	if err := r.ReadBody(&got); err != nil || got.Name != "ok" {
+		t.Fatalf("read body after oversized body: %v, %+v", err, got)
+	}
+}
+
+// 超出限制太多的帧不再丢弃, 直接断开连接
+func TestGobCodec_BodyFarTooLarge(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewGobCodec(a).(*GobCodec), NewGobCodec(b).(*GobCodec)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+	r.Configure(Config{MaxBodySize: 64})
+
+	go func() { _ = w.Write(&Header{Seq: 1}, bigArgs{Data: make([]byte, 1024)}) }()
+	var h Header
+	if err := r.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadBody(nil); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expect ErrBodyTooLarge, got %v", err)
+	}
+	if err := r.ReadHeader(&h); err == nil {
+		t.Fatal("expect connection to be closed")
+	}
+}
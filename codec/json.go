@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JsonCodec 的 header 和 body 都是独立的 JSON 文本, 方便其他语言对接
+type JsonCodec struct {
+	*frameCodec
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+// json的构造函数
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	c := &JsonCodec{}
+	c.frameCodec = newFrameCodec(conn, c)
+	return c
+}
+
+func (c *JsonCodec) marshalHeader(h *Header) ([]byte, error) {
+	return json.Marshal(h)
+}
+
+func (c *JsonCodec) unmarshalHeader(p []byte, h *Header) error {
+	return json.Unmarshal(p, h)
+}
+
+func (c *JsonCodec) marshalBody(body interface{}) ([]byte, error) {
+	return json.Marshal(body)
+}
+
+func (c *JsonCodec) unmarshalBody(p []byte, body interface{}) error {
+	return json.Unmarshal(p, body)
+}
@@ -0,0 +1,39 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackCodec 的 header 和 body 都是独立的 MessagePack 数据, 适合 Python/Node 等语言对接:
+// header 编码为以字段名为键的 map, 例如 {"ServiceMethod": "Foo.Sum", "Seq": 1, "Err": "", "Compressed": false},
+// body 按反射编码, 结构体同样编码为以字段名为键的 map, 可以用 `msgpack:"name"` 标签修改键名
+type MsgpackCodec struct {
+	*frameCodec
+}
+
+var _ Codec = (*MsgpackCodec)(nil)
+
+// msgpack的构造函数
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	c := &MsgpackCodec{}
+	c.frameCodec = newFrameCodec(conn, c)
+	return c
+}
+
+func (c *MsgpackCodec) marshalHeader(h *Header) ([]byte, error) {
+	return msgpack.Marshal(h)
+}
+
+func (c *MsgpackCodec) unmarshalHeader(p []byte, h *Header) error {
+	return msgpack.Unmarshal(p, h)
+}
+
+func (c *MsgpackCodec) marshalBody(body interface{}) ([]byte, error) {
+	return msgpack.Marshal(body)
+}
+
+func (c *MsgpackCodec) unmarshalBody(p []byte, body interface{}) error {
+	return msgpack.Unmarshal(p, body)
+}
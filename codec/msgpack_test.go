@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := NewMsgpackCodec(a), NewMsgpackCodec(b)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+
+	want := testArgs{Num1: 1, Num2: -2, Name: "msgpack"}
+	go func() { _ = w.Write(&Header{ServiceMethod: "Foo.Sum", Seq: math.MaxUint64}, want) }()
+
+	var h Header
+	var got testArgs
+	if err := r.ReadHeader(&h); err != nil || h.Seq != math.MaxUint64 || h.ServiceMethod != "Foo.Sum" {
+		t.Fatalf("read header: %v, %+v", err, h)
+	}
+	if err := r.ReadBody(&got); err != nil || got != want {
+		t.Fatalf("read body: %v, %+v", err, got)
+	}
+}
+
+// header 编码为以字段名为键的 map, 其他语言不需要 Go 的类型信息即可解码
+func TestMsgpackCodec_HeaderIsMap(t *testing.T) {
+	c := &MsgpackCodec{}
+	p, err := c.marshalHeader(&Header{ServiceMethod: "Foo.Sum", Seq: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := msgpack.Unmarshal(p, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["ServiceMethod"] != "Foo.Sum" || m["Seq"] != uint64(7) {
+		t.Fatalf("unexpected header map: %v", m)
+	}
+}
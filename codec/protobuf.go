@@ -0,0 +1,199 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufCodec 的 header 和 body 都是 protobuf 消息, 每条消息单独成帧;
+// 与其他编码方式不同, 长度前缀是 varint, 即 protobuf 标准的 length-delimited 格式 (Go 的 protodelim,
+// Java 的 writeDelimitedTo, C++ 的 SerializeDelimitedToOstream), 其他语言握手之后可以直接读写
+// 握手阶段的 Option 和 OptionReply 仍然是 4 字节长度前缀的 JSON, 见 service/handshake.go
+// header 的 schema 为:
+//
+//	message Header {
+//	  string service_method = 1;
+//	  uint64 seq            = 2;
+//	  string err            = 3;
+//	  bool   compressed     = 4;
+//	  uint32 code           = 5;
+//	  map<string, string> metadata = 6;
+//	  uint32 priority       = 7;
+//	}
+//
+// body 必须实现 proto.Message, 只有服务端出错时回复的空 body 例外
+type ProtobufCodec struct {
+	*frameCodec
+}
+
+var _ Codec = (*ProtobufCodec)(nil)
+
+// header 各字段的编号
+const (
+	pbServiceMethod protowire.Number = 1
+	pbSeq           protowire.Number = 2
+	pbErr           protowire.Number = 3
+	pbCompressed    protowire.Number = 4
+	pbCode          protowire.Number = 5
+	pbMetadata      protowire.Number = 6
+	pbPriority      protowire.Number = 7
+
+	// map entry 的 key 和 value
+	pbKey   protowire.Number = 1
+	pbValue protowire.Number = 2
+)
+
+// body 不是 proto.Message 时返回
+var ErrNotProtoMessage = errors.New("rpc codec: body does not implement proto.Message")
+
+// protobuf的构造函数
+func NewProtobufCodec(conn io.ReadWriteCloser) Codec {
+	c := &ProtobufCodec{}
+	c.frameCodec = newFrameCodec(conn, c)
+	c.varint = true
+	return c
+}
+
+func (c *ProtobufCodec) marshalHeader(h *Header) ([]byte, error) {
+	var b []byte
+	if h.ServiceMethod != "" {
+		b = protowire.AppendTag(b, pbServiceMethod, protowire.BytesType)
+		b = protowire.AppendString(b, h.ServiceMethod)
+	}
+	if h.Seq != 0 {
+		b = protowire.AppendTag(b, pbSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.Seq)
+	}
+	if h.Err != "" {
+		b = protowire.AppendTag(b, pbErr, protowire.BytesType)
+		b = protowire.AppendString(b, h.Err)
+	}
+	if h.Compressed {
+		b = protowire.AppendTag(b, pbCompressed, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	if h.Code != 0 {
+		b = protowire.AppendTag(b, pbCode, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Code))
+	}
+	if h.Priority != 0 {
+		b = protowire.AppendTag(b, pbPriority, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Priority))
+	}
+	// map 按 key 排序, 保证编码结果确定
+	keys := make([]string, 0, len(h.Metadata))
+	for k := range h.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, pbKey, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, pbValue, protowire.BytesType)
+		entry = protowire.AppendString(entry, h.Metadata[k])
+		b = protowire.AppendTag(b, pbMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+func (c *ProtobufCodec) unmarshalHeader(p []byte, h *Header) error {
+	*h = Header{}
+	for len(p) > 0 {
+		num, typ, n := protowire.ConsumeTag(p)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		p = p[n:]
+		switch {
+		case num == pbServiceMethod && typ == protowire.BytesType:
+			h.ServiceMethod, n = protowire.ConsumeString(p)
+		case num == pbSeq && typ == protowire.VarintType:
+			h.Seq, n = protowire.ConsumeVarint(p)
+		case num == pbErr && typ == protowire.BytesType:
+			h.Err, n = protowire.ConsumeString(p)
+		case num == pbCompressed && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(p)
+			h.Compressed = protowire.DecodeBool(v)
+		case num == pbCode && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(p)
+			h.Code = uint32(v)
+		case num == pbPriority && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(p)
+			h.Priority = uint8(v)
+		case num == pbMetadata && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(p)
+			if n >= 0 {
+				if err := unmarshalMetadataEntry(entry, h); err != nil {
+					return err
+				}
+			}
+		default:
+			// 跳过不认识的字段, 方便以后扩展 header
+			n = protowire.ConsumeFieldValue(num, typ, p)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func unmarshalMetadataEntry(p []byte, h *Header) error {
+	var k, v string
+	for len(p) > 0 {
+		num, typ, n := protowire.ConsumeTag(p)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		p = p[n:]
+		switch {
+		case num == pbKey && typ == protowire.BytesType:
+			k, n = protowire.ConsumeString(p)
+		case num == pbValue && typ == protowire.BytesType:
+			v, n = protowire.ConsumeString(p)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, p)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		p = p[n:]
+	}
+	if h.Metadata == nil {
+		h.Metadata = make(map[string]string)
+	}
+	h.Metadata[k] = v
+	return nil
+}
+
+func (c *ProtobufCodec) marshalBody(body interface{}) ([]byte, error) {
+	switch m := body.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case struct{}:
+		// 服务端出错时回复的空 body
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, body)
+	}
+}
+
+func (c *ProtobufCodec) unmarshalBody(p []byte, body interface{}) error {
+	m, ok := body.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, body)
+	}
+	return proto.Unmarshal(p, m)
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtobufCodec_Header(t *testing.T) {
+	c := &ProtobufCodec{}
+	want := Header{
+		ServiceMethod: "Foo.Sum", Seq: 42, Err: "boom", Code: 5, Compressed: true, Priority: 3,
+		Metadata: map[string]string{"retry-after": "1s", "traceparent": ""},
+	}
+	p, err := c.marshalHeader(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 末尾追加一个未知字段 (field 15, varint 1), 解码时应被跳过
+	p = append(p, 15<<3, 1)
+	var got Header
+	if err := c.unmarshalHeader(p, &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("unmarshal header: %v, got %+v", err, got)
+	}
+}
+
+func TestProtobufCodec_NotProtoMessage(t *testing.T) {
+	c := &ProtobufCodec{}
+	if _, err := c.marshalBody(testArgs{}); err == nil {
+		t.Fatal("expect an error for non proto.Message body")
+	}
+	if p, err := c.marshalBody(struct{}{}); err != nil || len(p) != 0 {
+		t.Fatalf("empty placeholder body should encode to nothing: %v", err)
+	}
+}
+
+// 帧格式与 protodelim 一致, 其他语言的 protobuf 库可以直接读写
+func TestProtobufCodec_Delimited(t *testing.T) {
+	a, b := net.Pipe()
+	c := NewProtobufCodec(a)
+	defer func() { _ = c.Close(); _ = b.Close() }()
+
+	go func() {
+		_ = c.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 1}, wrapperspb.String("hello"))
+	}()
+	r := bufio.NewReader(b)
+	if err := protodelim.UnmarshalFrom(r, &emptypb.Empty{}); err != nil {
+		t.Fatalf("read header with protodelim: %v", err)
+	}
+	var body wrapperspb.StringValue
+	if err := protodelim.UnmarshalFrom(r, &body); err != nil || body.Value != "hello" {
+		t.Fatalf("read body with protodelim: %v, got %q", err, body.Value)
+	}
+
+	go func() {
+		var hb []byte
+		hb, _ = (&ProtobufCodec{}).marshalHeader(&Header{ServiceMethod: "Foo.Echo", Seq: 2})
+		w := bufio.NewWriter(b)
+		_, _ = w.Write(binary.AppendUvarint(nil, uint64(len(hb))))
+		_, _ = w.Write(hb)
+		_, _ = protodelim.MarshalTo(w, wrapperspb.String("world"))
+		_ = w.Flush()
+	}()
+	var h Header
+	if err := c.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("read header: %v, got %+v", err, h)
+	}
+	if err := c.ReadBody(&body); err != nil || body.Value != "world" {
+		t.Fatalf("read body: %v, got %q", err, body.Value)
+	}
+}
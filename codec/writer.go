@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed 连接已关闭后再写入时返回
+var ErrClosed = errors.New("rpc codec: connection is closed")
+
+// 还没有写入任何数据时发生的错误, 连接仍然可用, 不需要关闭
+type encodeError struct{ err error }
+
+func (e *encodeError) Error() string { return e.err.Error() }
+func (e *encodeError) Unwrap() error { return e.err }
+
+// batchWriter 合并并发写入的 flush:
+// 调用方在自己的协程中持锁把消息编码进 buf, 后面没有其他写入方在等锁时立即 flush;
+// 否则把 flush 留给后面的写入方, 自己等待这一批 flush 完成, 这样并发写入的多条消息会被合并成一次系统调用
+// write 在消息真正 flush 之后才返回, flush 的错误会返回给这一批的所有写入方
+type batchWriter struct {
+	conn   io.Closer
+	buf    *bufio.Writer
+	encode func(*Header, interface{}) (int, error) // 将一条消息编码进 buf, 返回字节数, 持有 mu 时调用
+
+	waiting int32 // 正在等待 mu 的写入方个数
+	closed  int32
+
+	mu      sync.Mutex
+	flushed *sync.Cond // 每次 flush 或者写失败后广播
+	batch   uint64     // buf 中还没有 flush 的这一批的编号, 每次 flush 后加一
+	err     error      // 第一次写失败的错误, 之后的写入直接返回
+}
+
+func newBatchWriter(conn io.Closer, buf *bufio.Writer, encode func(*Header, interface{}) (int, error)) *batchWriter {
+	w := &batchWriter{conn: conn, buf: buf, encode: encode}
+	w.flushed = sync.NewCond(&w.mu)
+	return w
+}
+
+// write 可以被多个协程并发调用, 消息 flush 之后返回写入的字节数
+func (w *batchWriter) write(h *Header, body interface{}) (int, error) {
+	atomic.AddInt32(&w.waiting, 1)
+	w.mu.Lock()
+	atomic.AddInt32(&w.waiting, -1)
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if atomic.LoadInt32(&w.closed) != 0 {
+		// 前面的写入方可能在等这一批 flush, 让它们也返回
+		w.fail(ErrClosed)
+		return 0, ErrClosed
+	}
+	n, err := w.encode(h, body)
+	if err != nil {
+		var ee *encodeError
+		if errors.As(err, &ee) {
+			// 前面的写入方可能在等这一批 flush
+			if atomic.LoadInt32(&w.waiting) == 0 {
+				w.flush()
+			}
+			return 0, ee.err
+		}
+		w.fail(err)
+		return 0, err
+	}
+	if atomic.LoadInt32(&w.waiting) == 0 {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	// 后面的写入方拿到锁后会把这一批一起 flush
+	batch := w.batch
+	for w.batch == batch && w.err == nil {
+		w.flushed.Wait()
+	}
+	if w.batch == batch {
+		return 0, w.err
+	}
+	return n, nil
+}
+
+// 持有 mu 时调用
+func (w *batchWriter) flush() error {
+	if w.buf.Buffered() > 0 {
+		if err := w.buf.Flush(); err != nil {
+			w.fail(err)
+			return err
+		}
+	}
+	w.batch++
+	w.flushed.Broadcast()
+	return nil
+}
+
+// 写失败后连接状态不可信, 直接关闭连接; 持有 mu 时调用
+func (w *batchWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+	w.flushed.Broadcast()
+	_ = w.conn.Close()
+}
+
+// close 之后的写入返回 ErrClosed; write 返回时消息已经 flush, 不需要再等待
+// flush 阻塞时持有 mu, 这里不加锁, 由调用方关闭连接让 flush 返回
+func (w *batchWriter) close() {
+	atomic.StoreInt32(&w.closed, 1)
+}
@@ -1,3 +1,11 @@
 module GeeRPC
 
 go 1.23.0
+
+require (
+	github.com/golang/snappy v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.11
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
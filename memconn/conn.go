@@ -0,0 +1,231 @@
+package memconn
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 超时错误, 实现了 net.Error 且 Timeout() 为 true
+var errTimeout = os.ErrDeadlineExceeded
+
+// 一段写入的数据, readyAt 之后才能被读到
+type chunk struct {
+	data    []byte
+	readyAt time.Time
+}
+
+// pipe 是单向的带缓冲的数据通道
+type pipe struct {
+	mu       sync.Mutex
+	changed  chan struct{} // 状态变化时关闭并替换, 用于唤醒等待的读写方
+	chunks   []chunk
+	buffered int
+	lastSent time.Time // 按带宽计算的上一段数据发送完成的时间
+
+	rclosed bool // 读端关闭, 再写返回 io.ErrClosedPipe
+	wclosed bool // 写端关闭, 读完剩余数据后返回 io.EOF
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	latency   time.Duration
+	bandwidth int
+	size      int
+}
+
+func newPipe(opt *Options) *pipe {
+	p := &pipe{
+		changed:   make(chan struct{}),
+		latency:   opt.Latency,
+		bandwidth: opt.Bandwidth,
+		size:      opt.BufferSize,
+	}
+	if p.size <= 0 {
+		p.size = DefaultBufferSize
+	}
+	return p
+}
+
+// 必须持有锁
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// 等待状态变化、until 到达或 deadline 到达, 返回是否因 deadline 超时
+// 调用时持有锁, 返回时重新持有锁
+func (p *pipe) wait(until, deadline time.Time) bool {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return true
+	}
+	if !deadline.IsZero() && (until.IsZero() || deadline.Before(until)) {
+		until = deadline
+	}
+	changed := p.changed
+	p.mu.Unlock()
+	var timer <-chan time.Time
+	if !until.IsZero() {
+		t := time.NewTimer(time.Until(until))
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-changed:
+	case <-timer:
+	}
+	p.mu.Lock()
+	return false
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.rclosed {
+			return 0, net.ErrClosed
+		}
+		var until time.Time
+		if len(p.chunks) > 0 {
+			c := &p.chunks[0]
+			if !time.Now().Before(c.readyAt) {
+				n := copy(b, c.data)
+				c.data = c.data[n:]
+				if len(c.data) == 0 {
+					p.chunks = p.chunks[1:]
+				}
+				p.buffered -= n
+				p.notify()
+				return n, nil
+			}
+			until = c.readyAt
+		} else if p.wclosed {
+			return 0, io.EOF
+		}
+		if p.wait(until, p.readDeadline) {
+			return 0, errTimeout
+		}
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for n < len(b) {
+		if p.wclosed {
+			return n, net.ErrClosed
+		}
+		if p.rclosed {
+			return n, io.ErrClosedPipe
+		}
+		free := p.size - p.buffered
+		if free <= 0 {
+			if p.wait(time.Time{}, p.writeDeadline) {
+				return n, errTimeout
+			}
+			continue
+		}
+		m := len(b) - n
+		if m > free {
+			m = free
+		}
+		p.push(b[n : n+m])
+		n += m
+	}
+	return n, nil
+}
+
+// 复制数据放入队列, 按带宽和延迟计算可读时间, 必须持有锁
+func (p *pipe) push(b []byte) {
+	now := time.Now()
+	sent := now
+	if p.bandwidth > 0 {
+		if p.lastSent.After(sent) {
+			sent = p.lastSent
+		}
+		sent = sent.Add(time.Duration(len(b)) * time.Second / time.Duration(p.bandwidth))
+		p.lastSent = sent
+	}
+	p.chunks = append(p.chunks, chunk{data: append([]byte(nil), b...), readyAt: sent.Add(p.latency)})
+	p.buffered += len(b)
+	p.notify()
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rclosed = true
+	p.notify()
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wclosed = true
+	p.notify()
+}
+
+func (p *pipe) setReadDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	p.notify()
+}
+
+func (p *pipe) setWriteDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writeDeadline = t
+	p.notify()
+}
+
+// conn 是内存连接的一端, 实现了 net.Conn
+type conn struct {
+	r, w          *pipe
+	local, remote net.Addr
+	once          sync.Once
+}
+
+var _ net.Conn = (*conn)(nil)
+
+// 创建一对相连的连接, 两个方向使用相同的传输特性
+func newPair(name string, opt *Options) (client, server *conn) {
+	up, down := newPipe(opt), newPipe(opt)
+	client = &conn{r: down, w: up, local: addr(name + ":client"), remote: addr(name)}
+	server = &conn{r: up, w: down, local: addr(name), remote: addr(name + ":client")}
+	return client, server
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+// Close 关闭两个方向: 对端读完已发送的数据后得到 io.EOF, 对端再写得到 io.ErrClosedPipe
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		c.r.closeRead()
+		c.w.closeWrite()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	c.w.setWriteDeadline(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.w.setWriteDeadline(t)
+	return nil
+}
@@ -0,0 +1,128 @@
+// 进程内的内存传输, 用于单元测试: 不占用端口, 可以注入延迟和带宽限制
+//
+//	l, _ := memconn.Listen("", nil) // 空名字会自动分配一个唯一的名字
+//	go server.Accept(l)
+//	client, _ := service.Dial("mem", l.Addr().String())
+package memconn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 内存地址的网络名
+const Network = "mem"
+
+// Options 控制连接的传输特性, 零值表示没有延迟和带宽限制
+type Options struct {
+	Latency    time.Duration // 单向延迟, 写入的数据要经过这么久才能被读到
+	Bandwidth  int           // 单向带宽, 字节/秒, 0 表示不限
+	BufferSize int           // 单向缓冲区大小, 写满后 Write 阻塞, 0 表示 DefaultBufferSize
+}
+
+const DefaultBufferSize = 1 << 20
+
+var (
+	ErrNoListener = errors.New("memconn: no listener on address")
+	ErrAddrInUse  = errors.New("memconn: address already in use")
+)
+
+type addr string
+
+func (a addr) Network() string { return Network }
+func (a addr) String() string  { return string(a) }
+
+var (
+	listenersMu sync.Mutex
+	listeners   = make(map[string]*Listener)
+	nextID      uint64
+)
+
+// Listener 实现了 net.Listener, 可以直接交给 Server.Accept
+type Listener struct {
+	name  string
+	opt   Options
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// Listen 在 name 上监听, name 为空时自动分配; opt 为 nil 时没有延迟和带宽限制
+func Listen(name string, opt *Options) (*Listener, error) {
+	if name == "" {
+		name = fmt.Sprintf("mem-%d", atomic.AddUint64(&nextID, 1))
+	}
+	l := &Listener{
+		name:  name,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	if opt != nil {
+		l.opt = *opt
+	}
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	if _, dup := listeners[name]; dup {
+		return nil, ErrAddrInUse
+	}
+	listeners[name] = l
+	return l, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 停止监听, 已经建立的连接不受影响
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		listenersMu.Lock()
+		delete(listeners, l.name)
+		listenersMu.Unlock()
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return addr(l.name) }
+
+// Dial 连接这个 listener, 等待 Accept 取走连接, timeout 为 0 表示不限时
+func (l *Listener) Dial(timeout time.Duration) (net.Conn, error) {
+	client, server := newPair(l.name, &l.opt)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrNoListener
+	case <-expired:
+		return nil, fmt.Errorf("memconn: dial %s: %w", l.name, errTimeout)
+	}
+}
+
+// Dial 连接名为 name 的 listener
+func Dial(name string, timeout time.Duration) (net.Conn, error) {
+	listenersMu.Lock()
+	l := listeners[name]
+	listenersMu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoListener, name)
+	}
+	return l.Dial(timeout)
+}
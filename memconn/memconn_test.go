@@ -0,0 +1,111 @@
+package memconn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func dialPair(t *testing.T, opt *Options) (client, server net.Conn) {
+	t.Helper()
+	l, err := Listen("", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	client, err = Dial(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	t.Cleanup(func() { _ = client.Close(); _ = server.Close() })
+	return client, server
+}
+
+func TestConn_ReadWrite(t *testing.T) {
+	client, server := dialPair(t, nil)
+	go func() { _, _ = client.Write([]byte("hello")) }()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expect hello, got %q (%v)", buf, err)
+	}
+
+	// 关闭后对端先读完剩余数据, 再得到 EOF
+	_, _ = server.Write([]byte("bye"))
+	_ = server.Close()
+	data, err := io.ReadAll(client)
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("expect bye then EOF, got %q (%v)", data, err)
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Fatal("expect write to a closed peer to fail")
+	}
+}
+
+func TestConn_Latency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	client, server := dialPair(t, &Options{Latency: latency})
+	start := time.Now()
+	_, _ = client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < latency {
+		t.Fatalf("expect at least %s latency, got %s", latency, d)
+	}
+}
+
+func TestConn_Bandwidth(t *testing.T) {
+	// 10KB/s 传 2KB 至少要 200ms
+	client, server := dialPair(t, &Options{Bandwidth: 10 << 10})
+	start := time.Now()
+	go func() { _, _ = client.Write(make([]byte, 2<<10)) }()
+	if _, err := io.ReadFull(server, make([]byte, 2<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("expect at least 200ms at 10KB/s, got %s", d)
+	}
+}
+
+func TestConn_Deadline(t *testing.T) {
+	client, _ := dialPair(t, &Options{BufferSize: 4})
+	_ = client.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := client.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("expect a read timeout, got %v", err)
+	}
+
+	// 缓冲区写满后 Write 阻塞直到超时
+	_ = client.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	n, err := client.Write([]byte("overflow"))
+	if n != 4 || !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("expect 4 bytes then a write timeout, got %d (%v)", n, err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	l, err := Listen("svc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("svc", nil); !errors.Is(err, ErrAddrInUse) {
+		t.Fatalf("expect ErrAddrInUse, got %v", err)
+	}
+	_ = l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expect net.ErrClosed after close, got %v", err)
+	}
+	if _, err := Dial("svc", 0); !errors.Is(err, ErrNoListener) {
+		t.Fatalf("expect ErrNoListener, got %v", err)
+	}
+}
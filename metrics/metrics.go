@@ -0,0 +1,379 @@
+// Package metrics 是一个不依赖第三方库的指标实现, 以 Prometheus 文本格式输出:
+//
+//	reg := metrics.NewRegistry()
+//	calls := reg.NewCounter("geerpc_server_calls_total", "Calls handled.", "method")
+//	calls.With("Foo.Sum").Inc()
+//	http.Handle("/metrics", reg)
+//
+// 只实现了 counter, gauge 和 histogram 三种类型, 指标名和标签名由调用方保证合法
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType 是 Prometheus 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets 是默认的延迟分桶, 单位为秒, 与 Prometheus 客户端库相同
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry 是一组指标, 同一个 Registry 中的指标名不能重复
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// 指标名重复是程序错误, 直接 panic
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounter 创建一个只增不减的计数器, labels 为标签名
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, "counter", labels)}
+	r.register(name, v)
+	return v
+}
+
+// NewGauge 创建一个可增可减的值
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, "gauge", labels)}
+	r.register(name, v)
+	return v
+}
+
+// NewGaugeFunc 创建一个在输出时才取值的 gauge, 用于已经在别处统计的值
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, &gaugeFunc{name: name, help: help, f: f})
+}
+
+// NewHistogram 创建一个直方图, buckets 为升序的上界, 为空时使用 DefBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted: " + name)
+	}
+	v := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, v)
+	return v
+}
+
+// WriteTo 按注册顺序输出所有指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP 让 Registry 可以直接挂载为 /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	_ = Write(w, r)
+}
+
+// Write 依次输出多个 Registry, 用于在一个 HTTP 响应中合并多组指标
+func Write(w http.ResponseWriter, regs ...*Registry) error {
+	w.Header().Set("Content-Type", ContentType)
+	for _, r := range regs {
+		if _, err := r.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// 一组同名、标签值不同的时间序列
+type vec struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	metric interface{}
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+// 取出标签值对应的序列, 不存在时用 create 创建
+func (v *vec) with(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s := v.series[key]
+	v.mu.RUnlock()
+	if s != nil {
+		return s.metric
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s = v.series[key]; s == nil {
+		s = &series{values: append([]string(nil), values...), metric: create()}
+		v.series[key] = s
+	}
+	return s.metric
+}
+
+// DeletePartial 删除标签 label 的值为 value 的所有序列, 返回删除的个数
+// 标签值随时间变化 (如对端地址) 时用它清理不再使用的序列, 已经取出的指标继续可用但不再输出
+func (v *vec) DeletePartial(label, value string) int {
+	i := -1
+	for j, l := range v.labels {
+		if l == label {
+			i = j
+		}
+	}
+	if i < 0 {
+		panic(fmt.Sprintf("metrics: %s has no label %s", v.name, label))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for key, s := range v.series {
+		if s.values[i] == value {
+			delete(v.series, key)
+			n++
+		}
+	}
+	return n
+}
+
+// 按标签值排序, 输出稳定
+func (v *vec) sorted() []*series {
+	v.mu.RLock()
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	v.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].values, all[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return all
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.typ)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// 输出一行样本, extra 是额外的标签 (直方图的 le)
+func (v *vec) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue, value string) {
+	w.WriteString(v.name)
+	w.WriteString(suffix)
+	if len(v.labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range v.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabel(values[i]))
+			w.WriteByte('"')
+		}
+		if extraName != "" {
+			if len(v.labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec 是一组计数器
+type CounterVec struct{ vec }
+
+// With 返回标签值对应的计数器, 标签值的个数必须与标签名相同
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		v.writeSample(w, "", s.values, "", "", strconv.FormatUint(s.metric.(*Counter).Value(), 10))
+	}
+}
+
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc()          { atomic.AddUint64(&c.v, 1) }
+func (c *Counter) Add(n uint64)  { atomic.AddUint64(&c.v, n) }
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+// GaugeVec 是一组 gauge
+type GaugeVec struct{ vec }
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		v.writeSample(w, "", s.values, "", "", strconv.FormatInt(s.metric.(*Gauge).Value(), 10))
+	}
+}
+
+type Gauge struct {
+	v int64
+}
+
+func (g *Gauge) Inc()         { atomic.AddInt64(&g.v, 1) }
+func (g *Gauge) Dec()         { atomic.AddInt64(&g.v, -1) }
+func (g *Gauge) Add(n int64)  { atomic.AddInt64(&g.v, n) }
+func (g *Gauge) Set(n int64)  { atomic.StoreInt64(&g.v, n) }
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.v) }
+
+type gaugeFunc struct {
+	name, help string
+	f          func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// HistogramVec 是一组直方图, 共享同样的分桶
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values, func() interface{} {
+		return &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sorted() {
+		h := s.metric.(*Histogram)
+		counts, count, sum := h.snapshot()
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += counts[i]
+			v.writeSample(w, "_bucket", s.values, "le", formatFloat(le), strconv.FormatUint(cumulative, 10))
+		}
+		v.writeSample(w, "_bucket", s.values, "le", "+Inf", strconv.FormatUint(count, 10))
+		v.writeSample(w, "_sum", s.values, "", "", formatFloat(sum))
+		v.writeSample(w, "_count", s.values, "", "", strconv.FormatUint(count, 10))
+	}
+}
+
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // 每个桶自己的计数, 输出时再累加
+	count  uint64
+	sum    float64
+}
+
+// Observe 记录一个样本, 延迟以秒为单位
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.count, h.sum
+}
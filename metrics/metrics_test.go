@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	reg := NewRegistry()
+	calls := reg.NewCounter("calls_total", "Calls.", "method")
+	calls.With("B.Get").Add(2)
+	calls.With(`A."x"`).Inc()
+	inflight := reg.NewGauge("in_flight", "In flight.")
+	inflight.With().Inc()
+	inflight.With().Inc()
+	inflight.With().Dec()
+	reg.NewGaugeFunc("queued", "Queued\nrequests.", func() float64 { return 3 })
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	latency.With("A").Observe(0.05)
+	latency.With("A").Observe(0.1)
+	latency.With("A").Observe(5)
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP calls_total Calls.
+# TYPE calls_total counter
+calls_total{method="A.\"x\""} 1
+calls_total{method="B.Get"} 2
+# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP queued Queued\nrequests.
+# TYPE queued gauge
+queued 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="A",le="0.1"} 2
+latency_seconds_bucket{method="A",le="1"} 2
+latency_seconds_bucket{method="A",le="+Inf"} 3
+latency_seconds_sum{method="A"} 5.15
+latency_seconds_count{method="A"} 3
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("c_total", "C.").With().Inc()
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("c_total 1\n")) {
+		t.Fatalf("unexpected body:\n%s", rec.Body.String())
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("c_total", "C.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic on duplicate metric")
+		}
+	}()
+	reg.NewGauge("c_total", "C.")
+}
+
+func TestVec_DeletePartial(t *testing.T) {
+	reg := NewRegistry()
+	calls := reg.NewCounter("calls_total", "Calls.", "target", "method")
+	calls.With("a", "Foo.Get").Inc()
+	calls.With("a", "Foo.Put").Inc()
+	calls.With("b", "Foo.Get").Inc()
+	if n := calls.DeletePartial("target", "a"); n != 2 {
+		t.Fatalf("expect 2 series deleted, got %d", n)
+	}
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP calls_total Calls.
+# TYPE calls_total counter
+calls_total{target="b",method="Foo.Get"} 1
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
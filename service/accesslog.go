@@ -0,0 +1,125 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// 访问日志: 原生连接上的每次调用回复后记录一条, 包括方法、seq、对端、调用方身份 (WithPrincipal)、
+// 从读到请求到写出回复的耗时、状态码, 以及请求和回复在线上的字节数:
+//
+//	server.AccessLog = &service.AccessLog{
+//		Handler:       slog.NewJSONHandler(os.Stdout, nil),
+//		SampleEvery:   100,
+//		SlowThreshold: 500 * time.Millisecond,
+//	}
+//
+// 成功的调用记为 Info, 失败的记为 Warn
+// REST 和 JSON-RPC 网关的调用同样记录, 带上 gateway 字段, seq 为 0, 字节数是 HTTP body 的长度;
+// JSON-RPC 的批量请求中每个请求各记一条
+
+type AccessLog struct {
+	Handler slog.Handler // 为空时使用 slog.Default()
+	// 成功的调用每 SampleEvery 个记录一个, 0 和 1 表示全部记录; 失败的调用总是记录
+	SampleEvery int
+	// 耗时达到该值的调用总是记录, 0 表示不区分快慢
+	SlowThreshold time.Duration
+
+	count uint64
+}
+
+func (l *AccessLog) logger() *slog.Logger {
+	if l.Handler == nil {
+		return slog.Default()
+	}
+	return slog.New(l.Handler)
+}
+
+// 是否记录这次调用
+func (l *AccessLog) sampled(err error, d time.Duration) bool {
+	if err != nil || l.SampleEvery <= 1 || l.SlowThreshold > 0 && d >= l.SlowThreshold {
+		return true
+	}
+	return atomic.AddUint64(&l.count, 1)%uint64(l.SampleEvery) == 1
+}
+
+// 一次调用的访问记录, 随 ctx 传给拦截器, WithPrincipal 会把身份记在这里
+type accessEntry struct {
+	start     time.Time
+	peer      string
+	principal atomic.Value // string, 超时后处理函数仍可能在设置, 与 logAccess 并发
+	reqSize   int
+	gateway   string // 经过 HTTP 网关时为 "rest" 或 "jsonrpc"
+}
+
+func (e *accessEntry) setPrincipal(principal string) { e.principal.Store(principal) }
+
+func (e *accessEntry) getPrincipal() string {
+	p, _ := e.principal.Load().(string)
+	return p
+}
+
+type accessKey struct{}
+
+// 读到请求后创建访问记录, 没有配置访问日志时返回 nil
+func (server *Server) newAccessEntry(ctx context.Context, cc codec.Codec, start time.Time) *accessEntry {
+	if server.AccessLog == nil {
+		return nil
+	}
+	e := &accessEntry{start: start, peer: peerAddrOf(ctx)}
+	if s, ok := cc.(codec.Sizer); ok {
+		e.reqSize = s.ReadSize()
+	}
+	return e
+}
+
+// 网关读到请求后创建访问记录, reqSize 是请求 body 的字节数, 没有配置访问日志时返回 nil
+func (server *Server) newGatewayAccessEntry(ctx context.Context, gateway string, start time.Time, reqSize int) *accessEntry {
+	if server.AccessLog == nil {
+		return nil
+	}
+	return &accessEntry{start: start, peer: peerAddrOf(ctx), reqSize: reqSize, gateway: gateway}
+}
+
+func withAccessEntry(ctx context.Context, e *accessEntry) context.Context {
+	if e == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, accessKey{}, e)
+}
+
+// 调用已经回复
+func (server *Server) logAccess(e *accessEntry, h *codec.Header, resp response) {
+	if e == nil {
+		return
+	}
+	err, respSize := resp.err, resp.size
+	d := time.Since(e.start)
+	if !server.AccessLog.sampled(err, d) {
+		return
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("method", h.ServiceMethod),
+		slog.Uint64("seq", h.Seq),
+		slog.String("peer", e.peer),
+		slog.String("principal", e.getPrincipal()),
+		slog.Duration("duration", d),
+		slog.String("code", CodeOf(err).String()),
+		slog.Int("req_bytes", e.reqSize),
+		slog.Int("resp_bytes", respSize),
+	}
+	if e.gateway != "" {
+		attrs = append(attrs, slog.String("gateway", e.gateway))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	server.AccessLog.logger().LogAttrs(context.Background(), level, "rpc access", attrs...)
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 把日志记录发到通道中
+type recordHandler chan slog.Record
+
+func (h recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h <- r
+	return nil
+}
+func (h recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h recordHandler) WithGroup(string) slog.Handler      { return h }
+
+func (h recordHandler) next(t *testing.T) (slog.Level, map[string]slog.Value) {
+	t.Helper()
+	select {
+	case r := <-h:
+		attrs := make(map[string]slog.Value)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value
+			return true
+		})
+		return r.Level, attrs
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect an access log record")
+		return 0, nil
+	}
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	t.Parallel()
+	records := make(recordHandler, 16)
+	server := &Server{AccessLog: &AccessLog{Handler: records, SampleEvery: 2}}
+	server.Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		return next(WithPrincipal(ctx, "alice"), info)
+	})
+	client, err := Dial("mem", startTestServer(t, "mem", server, new(Echo)))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	for i := 0; i < 3; i++ {
+		_assert(client.Call("Echo.Echo", "hello", &reply) == nil, "call failed")
+	}
+	_assert(client.Call("Echo.Missing", "hello", &reply) != nil, "expect unknown method to fail")
+
+	// 成功的调用每 2 个记录一个
+	for _, seq := range []uint64{1, 3} {
+		level, attrs := records.next(t)
+		_assert(level == slog.LevelInfo && attrs["seq"].Uint64() == seq, "expect call %d logged at info, got %v %v", seq, level, attrs["seq"])
+		_assert(attrs["method"].String() == "Echo.Echo" && attrs["code"].String() == "OK", "unexpected record %v", attrs)
+		_assert(attrs["principal"].String() == "alice", "expect principal alice, got %v", attrs["principal"])
+		_assert(strings.HasPrefix(attrs["peer"].String(), "mem@"), "unexpected peer %v", attrs["peer"])
+		_assert(attrs["req_bytes"].Int64() > 0 && attrs["resp_bytes"].Int64() > 0, "expect sizes, got %v %v", attrs["req_bytes"], attrs["resp_bytes"])
+		_assert(attrs["duration"].Duration() > 0, "expect duration")
+	}
+	// 失败的调用总是记录
+	level, attrs := records.next(t)
+	_assert(level == slog.LevelWarn && attrs["code"].String() == "Unimplemented", "unexpected failure record %v %v", level, attrs)
+	_assert(attrs["error"].String() != "", "expect error message")
+	select {
+	case r := <-records:
+		t.Fatalf("unexpected record %v", r)
+	default:
+	}
+}
+
+// 超时后才设置身份的方法
+type LatePrincipal chan struct{}
+
+func (l LatePrincipal) Set(ctx context.Context, args string, reply *string) error {
+	<-ctx.Done()
+	WithPrincipal(ctx, args)
+	close(l)
+	return nil
+}
+
+// 超时后方法仍在设置身份, 不能与访问日志竞争 (用 -race 检查)
+func TestServer_AccessLogPrincipalAfterTimeout(t *testing.T) {
+	t.Parallel()
+	records := make(recordHandler, 16)
+	server := &Server{AccessLog: &AccessLog{Handler: records}, HandleTimeout: 10 * time.Millisecond}
+	set := make(LatePrincipal)
+	client, err := Dial("mem", startTestServer(t, "mem", server, set))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	_assert(client.Call("LatePrincipal.Set", "alice", &reply) != nil, "expect call to time out")
+	_, attrs := records.next(t)
+	_assert(attrs["code"].String() == "DeadlineExceeded", "unexpected record %v", attrs)
+	<-set
+}
+
+func TestAccessLog_Sampled(t *testing.T) {
+	l := &AccessLog{SampleEvery: 10, SlowThreshold: time.Second}
+	n := 0
+	for i := 0; i < 100; i++ {
+		if l.sampled(nil, 0) {
+			n++
+		}
+	}
+	_assert(n == 10, "expect 1 in 10 calls sampled, got %d", n)
+	_assert(l.sampled(nil, time.Second), "slow calls should always be logged")
+	_assert(l.sampled(ErrShutdown, 0), "failed calls should always be logged")
+}
+
+func TestServer_AccessLogGateways(t *testing.T) {
+	t.Parallel()
+	records := make(recordHandler, 16)
+	server := &Server{AccessLog: &AccessLog{Handler: records}}
+	server.Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		return next(WithPrincipal(ctx, "alice"), info)
+	})
+	_assert(server.Register(new(Echo)) == nil, "register failed")
+	mux := http.NewServeMux()
+	mux.Handle(DefaultRESTPath, server.RESTHandler())
+	mux.Handle("/jsonrpc", server.JSONRPCHandler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(path, body string) {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		_assert(err == nil, "post %s failed: %v", path, err)
+		_ = resp.Body.Close()
+	}
+	post(DefaultRESTPath+"Echo/Echo", `"hello"`)
+	level, attrs := records.next(t)
+	_assert(level == slog.LevelInfo && attrs["gateway"].String() == "rest", "expect rest record, got %v %v", level, attrs)
+	_assert(attrs["method"].String() == "Echo.Echo" && attrs["code"].String() == "OK", "unexpected record %v", attrs)
+	_assert(attrs["principal"].String() == "alice", "expect principal alice, got %v", attrs["principal"])
+	_assert(attrs["req_bytes"].Int64() == 7 && attrs["resp_bytes"].Int64() > 0, "expect sizes, got %v %v", attrs["req_bytes"], attrs["resp_bytes"])
+
+	post(DefaultRESTPath+"Echo/Missing", `"hello"`)
+	level, attrs = records.next(t)
+	_assert(level == slog.LevelWarn && attrs["code"].String() == "Unimplemented", "unexpected rest failure record %v %v", level, attrs)
+
+	// 批量请求中每个请求各记一条, 通知也记录
+	post("/jsonrpc", `[{"jsonrpc": "2.0", "method": "Echo.Echo", "params": "hi", "id": 1},
+		{"jsonrpc": "2.0", "method": "Echo.Echo", "params": "hi"},
+		{"jsonrpc": "1.0", "method": "Echo.Echo", "id": 2}]`)
+	for i, code := range []string{"OK", "OK", "InvalidArgument"} {
+		_, attrs = records.next(t)
+		_assert(attrs["gateway"].String() == "jsonrpc" && attrs["code"].String() == code, "request %d: expect %s, got %v", i, code, attrs)
+		_assert(attrs["req_bytes"].Int64() > 0, "request %d: expect request size", i)
+	}
+	_assert(attrs["resp_bytes"].Int64() > 0, "expect size of the error reply")
+	select {
+	case r := <-records:
+		t.Fatalf("unexpected record %v", r)
+	default:
+	}
+}
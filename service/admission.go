@@ -0,0 +1,195 @@
+package service
+
+import (
+	"sync"
+)
+
+// 准入控制: 限制所有连接和单个连接同时处理的请求数, 超过的请求进入有界队列等待,
+// 队列满时按 ShedPolicy 丢弃一个请求并回复 ErrOverloaded
+// 排队的请求按优先级调度: 先处理优先级高的, 同一优先级内按 ShedPolicy 的顺序; 丢弃时先丢优先级低的
+// 没有设置任何上限时请求直接处理, 优先级不起作用; 只作用于原生连接, HTTP 网关由 net/http 管理
+
+// 队列满时的丢弃策略
+type ShedPolicy int
+
+const (
+	RejectNewest ShedPolicy = iota // 先进先出, 拒绝新来的请求
+	RejectOldest                   // 先进先出, 丢弃等待最久的请求, 它多半已经等不到结果了
+	LIFO                           // 后进先出, 优先处理新来的请求, 队列满时丢弃等待最久的请求
+)
+
+// ErrOverloaded 表示请求被过载保护丢弃, 没有执行, 不论方法是否幂等都可以安全地重试 (见 IsShed)
+var ErrOverloaded = &Error{Code: Unavailable, Message: "rpc server: overloaded, request shed", Shed: true}
+
+// 一个等待处理的请求
+type task struct {
+	conn     *connSlots
+	priority Priority
+	run      func()          // 处理请求, 在新的协程中执行
+	reject   func(err error) // 回复错误, 请求不会再被处理
+}
+
+// 一个连接正在处理的请求数
+type connSlots struct {
+	running int
+}
+
+type scheduler struct {
+	mu          sync.Mutex
+	maxInFlight int
+	maxPerConn  int
+	maxQueue    int
+	policy      ShedPolicy
+	running     int
+	queue       []*task // 按到达顺序排列
+}
+
+// 没有设置任何上限时返回 nil, 请求直接新起协程处理, 不经过全局的锁
+// 这时没有请求需要等待, 按优先级调度也没有意义
+func (server *Server) scheduler() *scheduler {
+	server.schedOnce.Do(func() {
+		if server.MaxInFlight <= 0 && server.MaxConnInFlight <= 0 && server.MaxQueue <= 0 {
+			return
+		}
+		server.sched = &scheduler{
+			maxInFlight: server.MaxInFlight,
+			maxPerConn:  server.MaxConnInFlight,
+			maxQueue:    server.MaxQueue,
+			policy:      server.ShedPolicy,
+		}
+	})
+	return server.sched
+}
+
+// 必须持有锁
+func (s *scheduler) canRun(t *task) bool {
+	return (s.maxInFlight <= 0 || s.running < s.maxInFlight) &&
+		(s.maxPerConn <= 0 || t.conn.running < s.maxPerConn)
+}
+
+// 必须持有锁
+func (s *scheduler) start(t *task) {
+	s.running++
+	t.conn.running++
+	go func() {
+		defer s.done(t)
+		t.run()
+	}()
+}
+
+// 提交一个请求, 可以处理时新起协程处理, 否则排队或者被丢弃
+func (s *scheduler) submit(t *task) {
+	if s == nil {
+		go t.run()
+		return
+	}
+	var shed *task
+	s.mu.Lock()
+	switch {
+	case s.canRun(t):
+		s.start(t)
+	case len(s.queue) < s.maxQueue:
+		s.queue = append(s.queue, t)
+	default:
+		shed = s.victim(t)
+	}
+	s.mu.Unlock()
+	if shed != nil {
+		shed.reject(ErrOverloaded)
+	}
+}
+
+// 队列已满, 从队列和新来的请求 t 中选出要丢弃的一个, 必须持有锁
+// 只在优先级最低的请求中选: RejectNewest 丢最新的, 其余策略丢等待最久的
+func (s *scheduler) victim(t *task) *task {
+	candidates := append(s.queue, t)
+	lowest := t.priority
+	for _, c := range s.queue {
+		if c.priority < lowest {
+			lowest = c.priority
+		}
+	}
+	i := -1
+	for j, c := range candidates {
+		if c.priority != lowest {
+			continue
+		}
+		i = j
+		if s.policy != RejectNewest {
+			break
+		}
+	}
+	victim := candidates[i]
+	if victim != t {
+		s.queue = append(candidates[:i], candidates[i+1:]...)
+	}
+	return victim
+}
+
+// 请求处理完, 从队列中取出可以处理的请求
+func (s *scheduler) done(t *task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	t.conn.running--
+	for {
+		i := s.next()
+		if i < 0 {
+			return
+		}
+		next := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.start(next)
+	}
+}
+
+// 选出下一个可以处理的请求, 没有时返回 -1, 必须持有锁
+// 优先级高的先处理, 同一优先级内 LIFO 取最新的, 其余取最早的; 单个连接达到上限时跳过它的请求
+func (s *scheduler) next() int {
+	if s.maxInFlight > 0 && s.running >= s.maxInFlight {
+		return -1
+	}
+	best := -1
+	for i, t := range s.queue {
+		if !s.canRun(t) {
+			continue
+		}
+		if best < 0 || t.priority > s.queue[best].priority ||
+			t.priority == s.queue[best].priority && s.policy == LIFO {
+			best = i
+		}
+	}
+	return best
+}
+
+// 排队中的请求数
+func (s *scheduler) queued() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// 连接关闭时丢弃它还在排队的请求
+func (s *scheduler) dropConn(conn *connSlots) {
+	if s == nil {
+		return
+	}
+	var dropped []*task
+	s.mu.Lock()
+	queue := s.queue[:0]
+	for _, t := range s.queue {
+		if t.conn == conn {
+			dropped = append(dropped, t)
+		} else {
+			queue = append(queue, t)
+		}
+	}
+	s.queue = queue
+	s.mu.Unlock()
+	for _, t := range dropped {
+		t.reject(ErrShutdown)
+	}
+}
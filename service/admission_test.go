@@ -0,0 +1,201 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// 调用开始时把参数发到 started, 然后阻塞到 release 关闭
+type Gate struct {
+	started chan int
+	release chan struct{}
+}
+
+func newGate() *Gate {
+	return &Gate{started: make(chan int, 16), release: make(chan struct{})}
+}
+
+func (g *Gate) Wait(args int, reply *int) error {
+	g.started <- args
+	<-g.release
+	*reply = args
+	return nil
+}
+
+func (g *Gate) expectStarted(t *testing.T, want int) {
+	t.Helper()
+	select {
+	case got := <-g.started:
+		_assert(got == want, "expect call %d to start, got %d", want, got)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("call %d did not start", want)
+	}
+}
+
+// 在内存连接上启动注册了 Gate 的服务器, 返回 Gate 和连接它的客户端
+func startGateServer(t *testing.T, server *Server) (*Gate, *Client) {
+	t.Helper()
+	g := newGate()
+	client, err := Dial("mem", startTestServer(t, "mem", server, g))
+	_assert(err == nil, "dial failed: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return g, client
+}
+
+// 等待 n 个请求进入队列
+func expectQueued(t *testing.T, server *Server, n int) {
+	t.Helper()
+	waitFor(t, func() bool { return server.scheduler().queued() == n }, "expect %d queued calls, got %d", n, server.scheduler().queued())
+}
+
+func goCall(client *Client, n int) *Call {
+	return client.Go("Gate.Wait", n, new(int), make(chan *Call, 1))
+}
+
+func TestServer_AdmissionRejectNewest(t *testing.T) {
+	t.Parallel()
+	g, client := startGateServer(t, &Server{MaxInFlight: 1, MaxQueue: 1, ShedPolicy: RejectNewest})
+	c1 := goCall(client, 1)
+	g.expectStarted(t, 1)
+	c2, c3 := goCall(client, 2), goCall(client, 3)
+
+	call := <-c3.Done
+	_assert(IsShed(call.Error) && CodeOf(call.Error) == Unavailable, "expect newest call shed, got %v", call.Error)
+	close(g.release)
+	_assert((<-c1.Done).Error == nil, "first call should succeed")
+	_assert((<-c2.Done).Error == nil, "queued call should succeed")
+}
+
+func TestServer_AdmissionRejectOldest(t *testing.T) {
+	t.Parallel()
+	g, client := startGateServer(t, &Server{MaxInFlight: 1, MaxQueue: 1, ShedPolicy: RejectOldest})
+	c1 := goCall(client, 1)
+	g.expectStarted(t, 1)
+	c2, c3 := goCall(client, 2), goCall(client, 3)
+
+	call := <-c2.Done
+	_assert(IsShed(call.Error), "expect oldest queued call shed, got %v", call.Error)
+	close(g.release)
+	_assert((<-c1.Done).Error == nil, "first call should succeed")
+	_assert((<-c3.Done).Error == nil, "newest call should succeed")
+}
+
+func TestServer_AdmissionLIFO(t *testing.T) {
+	t.Parallel()
+	server := &Server{MaxInFlight: 1, MaxQueue: 2, ShedPolicy: LIFO}
+	g, client := startGateServer(t, server)
+
+	calls := []*Call{goCall(client, 1)}
+	g.expectStarted(t, 1)
+	calls = append(calls, goCall(client, 2), goCall(client, 3))
+	expectQueued(t, server, 2)
+	close(g.release)
+	g.expectStarted(t, 3)
+	g.expectStarted(t, 2)
+	for _, c := range calls {
+		_assert((<-c.Done).Error == nil, "call %v failed", c.Args)
+	}
+}
+
+func TestServer_AdmissionPerConn(t *testing.T) {
+	t.Parallel()
+	server := &Server{MaxConnInFlight: 1, MaxQueue: 10}
+	g := newGate()
+	addr := startTestServer(t, "mem", server, g)
+	busy, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = busy.Close() }()
+	other, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = other.Close() }()
+
+	c1 := goCall(busy, 1)
+	g.expectStarted(t, 1)
+	c2 := goCall(busy, 2)
+	// 另一个连接不受这个连接的上限影响
+	c3 := goCall(other, 3)
+	g.expectStarted(t, 3)
+	close(g.release)
+	g.expectStarted(t, 2)
+	for _, c := range []*Call{c1, c2, c3} {
+		_assert((<-c.Done).Error == nil, "call %v failed", c.Args)
+	}
+}
+
+func TestServer_AdmissionPriority(t *testing.T) {
+	t.Parallel()
+	server := &Server{MaxInFlight: 1, MaxQueue: 2}
+	g, client := startGateServer(t, server)
+	c1 := goCall(client, 1)
+	g.expectStarted(t, 1)
+	low := client.Go("Gate.Wait", 2, new(int), make(chan *Call, 1), WithPriority(PriorityLow))
+	high := client.Go("Gate.Wait", 3, new(int), make(chan *Call, 1), WithPriority(PriorityHigh))
+	expectQueued(t, server, 2)
+	// 优先级高的请求先处理, 即使它来得晚
+	close(g.release)
+	g.expectStarted(t, 3)
+	g.expectStarted(t, 2)
+	for _, c := range []*Call{c1, low, high} {
+		_assert((<-c.Done).Error == nil, "call %v failed", c.Args)
+	}
+}
+
+func TestServer_AdmissionShedLowPriority(t *testing.T) {
+	t.Parallel()
+	g, client := startGateServer(t, &Server{MaxInFlight: 1, MaxQueue: 1, ShedPolicy: RejectNewest})
+	c1 := goCall(client, 1)
+	g.expectStarted(t, 1)
+	low := client.Go("Gate.Wait", 2, new(int), make(chan *Call, 1), WithPriority(PriorityLow))
+	// 队列满时先丢弃优先级低的请求, 即使策略是拒绝新请求
+	normal := goCall(client, 3)
+	call := <-low.Done
+	_assert(IsShed(call.Error), "expect low priority call shed, got %v", call.Error)
+	// 新来的低优先级请求被拒绝
+	call = <-client.Go("Gate.Wait", 4, new(int), make(chan *Call, 1), WithPriority(PriorityLow)).Done
+	_assert(IsShed(call.Error), "expect new low priority call shed, got %v", call.Error)
+	close(g.release)
+	_assert((<-c1.Done).Error == nil, "first call should succeed")
+	_assert((<-normal.Done).Error == nil, "normal priority call should succeed")
+}
+
+// 没有设置上限时不创建调度器, 请求直接处理
+func TestServer_AdmissionUnlimited(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.scheduler() == nil, "expect no scheduler without limits")
+	gate := newGate()
+	client, err := Dial("mem", startTestServer(t, "mem", server, gate))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 3)
+	for i := range calls {
+		calls[i] = client.Go("Gate.Wait", i, new(int), make(chan *Call, 1))
+	}
+	for range calls {
+		select {
+		case <-gate.started:
+		case <-time.After(time.Second):
+			t.Fatal("calls should run concurrently without limits")
+		}
+	}
+	close(gate.release)
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "call failed: %v", call.Error)
+	}
+}
+
+// 没有设置上限时优先级不起作用: 低优先级的请求不会等高优先级的请求
+func TestServer_PriorityWithoutLimits(t *testing.T) {
+	t.Parallel()
+	g, client := startGateServer(t, &Server{MaxQueue: 10})
+	high := client.Go("Gate.Wait", 1, new(int), make(chan *Call, 1), WithPriority(PriorityHigh))
+	g.expectStarted(t, 1)
+	low := client.Go("Gate.Wait", 2, new(int), make(chan *Call, 1), WithPriority(PriorityLow))
+	g.expectStarted(t, 2)
+	close(g.release)
+	for _, c := range []*Call{high, low} {
+		_assert((<-c.Done).Error == nil, "call %v failed", c.Args)
+	}
+}
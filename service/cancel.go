@@ -0,0 +1,53 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"context"
+	"sync"
+)
+
+// 取消帧: 客户端放弃等待一个调用时 (CallContext 的 ctx 结束), 发送 Seq 为该调用的取消帧,
+// 服务端收到后取消这个调用的 ctx; 服务端随后的回复会被客户端丢弃
+// 不认识取消帧的旧服务端会把它当作未知方法回复一个错误, 同样会被丢弃
+const cancelMethod = "_geerpc.Cancel"
+
+func isCancel(h *codec.Header) bool {
+	return h.Seq != 0 && h.ServiceMethod == cancelMethod
+}
+
+func sendCancel(cc codec.Codec, seq uint64) error {
+	return cc.Write(&codec.Header{ServiceMethod: cancelMethod, Seq: seq}, invalidRequest)
+}
+
+// 一个连接上正在处理的调用, 按 Seq 保存它们的 cancel
+type inflight struct {
+	mu      sync.Mutex
+	cancels map[uint64]context.CancelFunc
+}
+
+func newInflight() *inflight {
+	return &inflight{cancels: make(map[uint64]context.CancelFunc)}
+}
+
+// 为调用创建可以被取消帧取消的 ctx, 调用结束后必须调用返回的 done
+func (f *inflight) add(ctx context.Context, seq uint64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	f.mu.Lock()
+	f.cancels[seq] = cancel
+	f.mu.Unlock()
+	return ctx, func() {
+		f.mu.Lock()
+		delete(f.cancels, seq)
+		f.mu.Unlock()
+		cancel()
+	}
+}
+
+func (f *inflight) cancel(seq uint64) {
+	f.mu.Lock()
+	cancel := f.cancels[seq]
+	f.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -2,12 +2,15 @@ package service
 
 import (
 	"GeeRPC/codec"
-	"encoding/json"
+	"GeeRPC/trace"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,24 +21,48 @@ type Call struct {
 	Args          interface{}
 	Reply         interface{}
 	Error         error
-	Done          chan *Call // 异步调用时, 用于通知用户完成
+	Done          chan *Call        // 异步调用时, 用于通知用户完成
+	Priority      Priority          // 0 表示默认, 见 WithPriority
+	Metadata      map[string]string // 随请求 header 发送, 见 WithMetadata
+
+	stats *targetMetrics // 为空表示不统计, 见 metrics.go
+	start time.Time
+	span  *trace.Span // 设置了 Option.Tracer 时为调用创建的 client span
 }
 
 // 通知客户端调用结束
 func (call *Call) done() {
+	call.observe()
 	call.Done <- call
 }
 
+func (call *Call) observe() {
+	if call.stats != nil {
+		call.stats.end(call)
+		call.stats = nil
+	}
+	if call.span != nil {
+		call.span.Finish(CodeOf(call.Error).String(), call.Error)
+		call.span = nil
+	}
+}
+
+// Client 只使用一条连接, 不会重连也不会重试; 重试策略和重新选择服务器见 xclient.RetryPolicy
 type Client struct {
 	cc       codec.Codec
 	opt      *Option
-	sending  sync.Mutex   // 保证请求有序发送
-	header   codec.Header // 由于发送是互斥的, 所以客户端所有请求复用一个header就行
-	mu       sync.Mutex   //protect following
+	mu       sync.Mutex //protect following
 	seq      uint64
 	pending  map[uint64]*Call
 	closing  bool // 用户主动关闭
 	shutdown bool // 发生错误关闭
+	draining bool // 收到服务端的 goaway, 不再发出新的调用, 见 shutdown.go
+	drained  bool // 调用都已经结束, 连接已经关闭
+	live     *liveness
+	stopped  chan struct{}  // receive 退出时关闭
+	stats    *targetMetrics // 为空表示不统计
+
+	idempotent map[string]bool // 服务端声明为幂等的方法, 握手后不再修改
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -50,6 +77,13 @@ func (client *Client) Close() error {
 		return ErrShutdown
 	}
 	client.closing = true
+	if client.drained {
+		return nil
+	}
+	// 服务端正在关闭, 还在等待的调用仍会收到回复, 最后一个回复到达后由 closeIfDrained 关闭连接
+	if client.draining && len(client.pending) > 0 {
+		return nil
+	}
 	return client.cc.Close()
 }
 
@@ -57,7 +91,7 @@ func (client *Client) Close() error {
 func (client *Client) IsAvalable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.closing && !client.shutdown
+	return !client.closing && !client.shutdown && !client.draining
 }
 
 // 用户参数配置
@@ -69,12 +103,13 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
+	// 复制一份, 同一个 Option 可能被多个协程同时用来连接
+	opt := *opts[0]
 	opt.OptionIdentify = DefaultOption.OptionIdentify
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	return opt, nil
+	return &opt, nil
 }
 
 // 超时处理添加
@@ -90,8 +125,8 @@ func dialTimeout(f newClientFunc, network, addr string, opts ...*Option) (client
 	if err != nil {
 		return nil, err
 	}
-	// 建立tcp连接, 如果超时返回错误
-	conn, err := net.DialTimeout(network, addr, opt.ConnectTimeout)
+	// 建立连接, 如果超时返回错误
+	conn, err := dialConn(network, addr, opt.ConnectTimeout, opt.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -122,44 +157,83 @@ func dialTimeout(f newClientFunc, network, addr string, opts ...*Option) (client
 	}
 }
 
-// 连接指定地址的rpc server
+// 连接指定地址的rpc server, 支持的网络见 dialConn
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
 	return dialTimeout(NewClient, network, address, opts...)
 }
 
 // 启动client
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error:", err)
-		return nil, err
-	}
-
+	target := targetOf(conn)
+	stats := clientStats.acquire(target)
+	conn = clientStats.countConn(stats, conn)
 	// 协议交换
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: option error: ", err)
+	cc, opt, reply, err := clientHandshake(conn, opt)
+	if err != nil {
+		slog.Warn("rpc client: handshake failed", "target", target, "err", err)
+		clientStats.handshakeFailures.Inc()
+		clientStats.release(stats)
 		_ = conn.Close()
 		return nil, err
 	}
-	time.Sleep(time.Second)
-	return NewClientWithCodec(f(conn), opt), nil
+	client := newClientWithCodec(cc, opt, stats)
+	client.idempotent = make(map[string]bool, len(reply.Idempotent))
+	for _, m := range reply.Idempotent {
+		client.idempotent[m] = true
+	}
+	return client, nil
+}
+
+// rpcAddr 形如 network@addr, 例如 tcp@127.0.0.1:9999, unix@/tmp/geerpc.sock, mem@name
+func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
+	parts := strings.SplitN(rpcAddr, "@", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect network@addr", rpcAddr)
+	}
+	return Dial(parts[0], parts[1], opts...)
+}
+
+// 服务端是否声明了 serviceMethod 是幂等的
+func (client *Client) IsIdempotent(serviceMethod string) bool {
+	return client.idempotent[serviceMethod]
 }
 
 // 创建实例并起routine进行数据接受
 func NewClientWithCodec(codec codec.Codec, opt *Option) *Client {
+	return newClientWithCodec(codec, opt, nil)
+}
+
+func newClientWithCodec(codec codec.Codec, opt *Option, stats *targetMetrics) *Client {
 	client := &Client{
 		seq:     1,
 		cc:      codec,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		live:    newLiveness(),
+		stopped: make(chan struct{}),
+		stats:   stats,
+	}
+	if stats != nil {
+		stats.conns.Inc()
 	}
 	go client.receive()
+	if opt.HeartbeatInterval > 0 {
+		go client.live.run(codec, opt.HeartbeatInterval, slog.Default(), client.stopped, func() {
+			// 先以明确的错误结束 pending 的调用, 再关闭连接让 receive 退出
+			client.terminateCall(ErrHeartbeatTimeout)
+			_ = client.cc.Close()
+		})
+	}
 	return client
 }
 
 // 接受响应
 func (client *Client) receive() {
+	defer close(client.stopped)
+	if client.stats != nil {
+		defer clientStats.release(client.stats)
+		defer client.stats.conns.Dec()
+	}
 	var err error
 	for err == nil {
 		var h codec.Header
@@ -167,6 +241,17 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		client.live.touch()
+		if isGoAway(&h) {
+			if err = client.cc.ReadBody(nil); err == nil {
+				client.goAway()
+			}
+			continue
+		}
+		var ok bool
+		if ok, err = handleHeartbeat(client.cc, &h); ok {
+			continue
+		}
 		call := client.removeCall(h.Seq)
 
 		switch {
@@ -174,26 +259,52 @@ func (client *Client) receive() {
 			// call已经被提走了
 			err = client.cc.ReadBody(nil)
 		case h.Err != "":
-			// 服务器处理调用出错
-			call.Error = fmt.Errorf(h.Err)
+			// 服务器处理调用出错, 保留错误码
+			call.Error = headerError(&h)
 			err = client.cc.ReadBody(nil)
 			call.done()
 
 		default:
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body" + err.Error())
+				call.Error = errors.New("reading body " + err.Error())
 			}
 			call.done()
+			// 超长的 body 已被整帧丢弃, 连接仍然可用
+			if errors.Is(err, codec.ErrBodyTooLarge) {
+				err = nil
+			}
+		}
+		if err == nil {
+			client.closeIfDrained()
 		}
 	}
 	// EOF, 一般来说读到EOF说明服务器关闭了连接
 	client.terminateCall(err)
 }
 
+// 将回复 header 中的错误还原为带错误码的错误
+func headerError(h *codec.Header) error {
+	code := Code(h.Code)
+	if code == OK {
+		code = Unknown
+	}
+	e := &Error{Code: code, Message: h.Err, Shed: h.Metadata[MetadataShed] == "true"}
+	if v, ok := h.Metadata[MetadataRetryAfter]; ok {
+		e.RetryAfter, _ = time.ParseDuration(v)
+	}
+	return e
+}
+
 // 异步调用方法
 // 实际使用中可以使用同步接口Call, 或者新起一个routine去等待返回
-func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	return client.goContext(context.Background(), serviceMethod, args, reply, done, opts)
+}
+
+// Go, Call 和 CallContext 共用的发送路径
+// ctx 中有 span 时把它的上下文通过 metadata 传给服务端; 设置了 Option.Tracer 时还会为调用创建一个 client span, 调用结束时结束
+func (client *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call, opts []CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call)
 	} else if cap(done) == 0 {
@@ -206,21 +317,52 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Reply:         reply,
 		Done:          done,
 	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if tracer := client.opt.Tracer; tracer != nil {
+		ctx, call.span = tracer.Start(ctx, serviceMethod, trace.SpanKindClient)
+		call.span.SetAttribute("rpc.system", "geerpc")
+		call.span.SetAttribute("rpc.method", serviceMethod)
+		if client.stats != nil {
+			call.span.SetAttribute("net.peer.addr", client.stats.target)
+		}
+	}
+	if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		setMetadata(call, trace.TraceparentKey, sc.Traceparent())
+	}
 	client.send(call)
 	return call
 }
 
 // 同步接口, 阻塞了call.Done
-func (client *Client) Call(serviceMethod string, args, reply interface{}) error {
-	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
+func (client *Client) Call(serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1), opts...).Done
 	return call.Error
 }
 
+// 带 context 的同步接口, ctx 结束时不再等待回复
+// ctx 中的 span 会传给服务端, 见 goContext
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	call := client.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1), opts)
+	select {
+	case <-ctx.Done():
+		err := fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+		// 调用仍在等待回复时通知服务端取消
+		if client.removeCall(call.Seq) != nil {
+			_ = sendCancel(client.cc, call.Seq)
+			call.Error = err
+			call.observe()
+		}
+		return err
+	case call := <-call.Done:
+		return call.Error
+	}
+}
+
 // 发送调用请求
+// Codec 的 Write 可以并发调用, 这里不再加锁, 并发的请求合并后一起 flush
 func (client *Client) send(call *Call) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
-
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
@@ -229,11 +371,14 @@ func (client *Client) send(call *Call) {
 	}
 
 	// request header
-	client.header.ServiceMethod = call.ServiceMethod
-	client.header.Seq = seq
-	client.header.Err = ""
+	h := &codec.Header{
+		ServiceMethod: call.ServiceMethod,
+		Seq:           seq,
+		Priority:      uint8(call.Priority),
+		Metadata:      call.Metadata,
+	}
 
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	if err := client.cc.Write(h, call.Args); err != nil {
 		call := client.removeCall(seq)
 		// call 如果为空, 说明write部分失败, 但客户端仍然收到了响应并处理了call
 		if call != nil {
@@ -250,9 +395,16 @@ func (client *Client) registerCall(call *Call) (uint64, error) {
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
+	// 服务端不会处理这个调用, 可以换一个节点重试
+	if client.draining {
+		return 0, errServerShutdown
+	}
 
 	call.Seq = client.seq
 	client.pending[call.Seq] = call
+	if client.stats != nil {
+		client.stats.begin(call)
+	}
 	client.seq++
 	return call.Seq, nil
 }
@@ -266,14 +418,32 @@ func (client *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
+// 服务端正在关闭
+func (client *Client) goAway() {
+	client.mu.Lock()
+	client.draining = true
+	client.mu.Unlock()
+	client.closeIfDrained()
+}
+
+// 收到 goaway 且调用都已经回复时关闭连接, 服务端读到 EOF 后释放这条连接
+// 只在 receive 中调用, 不会丢掉还没有读完的回复
+func (client *Client) closeIfDrained() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.draining && !client.drained && len(client.pending) == 0 {
+		client.drained = true
+		_ = client.cc.Close()
+	}
+}
+
 // 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call
 func (client *Client) terminateCall(err error) {
-	client.sending.Lock()
-	defer client.sending.Unlock()
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
 		call.done()
 	}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"GeeRPC/foo"
 	"net"
 	"strings"
 	"testing"
@@ -26,3 +27,41 @@ func TestClient_dialTimeout(t *testing.T) {
 		_assert(err == nil, "0 means no limit")
 	})
 }
+
+// 单个协程串行调用, 每条消息都要单独 flush
+func BenchmarkClient_Call(b *testing.B) {
+	client, err := Dial("tcp", startTestServer(b, "tcp", NewServer(), new(foo.Foo)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	args := &foo.Args{Num1: 1, Num2: 2}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var reply int
+		if err := client.Call("Foo.Sum", args, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 多个协程并发调用同一个 client, 并发的请求和回复会合并后再 flush
+func BenchmarkClient_CallParallel(b *testing.B) {
+	client, err := Dial("tcp", startTestServer(b, "tcp", NewServer(), new(foo.Foo)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		args := &foo.Args{Num1: 1, Num2: 2}
+		for pb.Next() {
+			var reply int
+			if err := client.Call("Foo.Sum", args, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "calls/s")
+}
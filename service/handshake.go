@@ -0,0 +1,160 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// 握手过程:
+// 1. 客户端发送 Option 帧, CodecTypes 按偏好顺序列出自己支持的编码方式
+// 2. 服务端按客户端的顺序选出第一个双方都支持的编码方式, 回复 OptionReply 帧
+// 3. 之后双方都使用选定的编码方式读写 header 和 body
+
+// 服务端对 Option 的应答, 同样是 JSON 编码
+type OptionReply struct {
+	CodecType  codec.Type // 选定的编码方式
+	Compressor string     // 选定的压缩算法, 服务端不支持客户端要求的算法时为空
+	Idempotent []string   // 服务端声明为幂等的方法, 见 IdempotentMethods
+	Err        string     // 非空表示握手失败, 服务端随后关闭连接
+}
+
+// 客户端支持的编码方式, 按偏好排序
+func (opt *Option) codecTypes() []codec.Type {
+	if len(opt.CodecTypes) > 0 {
+		return opt.CodecTypes
+	}
+	return []codec.Type{opt.CodecType}
+}
+
+// 服务端支持的编码方式
+func (server *Server) codecTypes() []codec.Type {
+	if len(server.CodecTypes) > 0 {
+		return server.CodecTypes
+	}
+	return codec.Types()
+}
+
+// 选出第一个双方都支持的编码方式
+func (server *Server) pickCodecType(opt *Option) (codec.Type, error) {
+	for _, t := range opt.codecTypes() {
+		if codec.Lookup(t) == nil {
+			continue
+		}
+		for _, st := range server.codecTypes() {
+			if t == st {
+				return t, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no mutual codec type in %v, server supports %v", opt.codecTypes(), server.codecTypes())
+}
+
+func writeJSONFrame(w io.Writer, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return codec.WriteFrame(w, p)
+}
+
+func readJSONFrame(r io.Reader, max int, v interface{}) error {
+	p, err := codec.ReadFrame(r, max)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// 服务端握手, 成功时返回配置好的 codec
+func (server *Server) handshake(conn io.ReadWriteCloser) (codec.Codec, error) {
+	var opt Option
+	// 读取 Option 帧并用 json 解码, 超过 header 上限的直接断开
+	if err := readJSONFrame(conn, server.MaxHeaderSize, &opt); err != nil {
+		return nil, err
+	}
+	if opt.OptionIdentify != Identify {
+		return nil, fmt.Errorf("invalid identifer %x", opt.OptionIdentify)
+	}
+
+	var reply OptionReply
+	t, err := server.pickCodecType(&opt)
+	if err == nil && server.protobufOnly() && atomic.LoadInt32(&server.nonProto) != 0 {
+		// Register 之后才把 CodecTypes 改成只有 protobuf, 已注册的方法无法调用
+		err = errors.New("server only accepts protobuf but has methods registered without proto.Message types")
+	}
+	if err != nil {
+		reply.Err = err.Error()
+		_ = writeJSONFrame(conn, &reply)
+		return nil, err
+	}
+	reply.CodecType = t
+	reply.Idempotent = server.idempotentMethods()
+	if opt.Compressor != "" && codec.GetCompressor(opt.Compressor) != nil {
+		reply.Compressor = opt.Compressor
+	}
+	if err := writeJSONFrame(conn, &reply); err != nil {
+		return nil, err
+	}
+
+	// 根据协商结果进行head和body解码
+	cc := codec.Lookup(t)(conn)
+	if c, ok := cc.(codec.Configurable); ok {
+		c.Configure(codec.Config{
+			MaxHeaderSize:     server.MaxHeaderSize,
+			MaxBodySize:       server.MaxBodySize,
+			Compressor:        reply.Compressor,
+			CompressThreshold: opt.CompressThreshold,
+		})
+	}
+	return cc, nil
+}
+
+// 客户端握手, 返回配置好的 codec, 协商后的 Option 和服务端的应答
+func clientHandshake(conn io.ReadWriteCloser, opt *Option) (codec.Codec, *Option, *OptionReply, error) {
+	// 只发送本地已注册的编码方式
+	o := *opt
+	o.CodecTypes = nil
+	for _, t := range opt.codecTypes() {
+		if codec.Lookup(t) != nil {
+			o.CodecTypes = append(o.CodecTypes, t)
+		}
+	}
+	if len(o.CodecTypes) == 0 {
+		return nil, nil, nil, fmt.Errorf("invalid codec type %v", opt.codecTypes())
+	}
+	if o.Compressor != "" && codec.GetCompressor(o.Compressor) == nil {
+		return nil, nil, nil, fmt.Errorf("invalid compressor %s", o.Compressor)
+	}
+	if err := writeJSONFrame(conn, &o); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var reply OptionReply
+	if err := readJSONFrame(conn, o.MaxHeaderSize, &reply); err != nil {
+		return nil, nil, nil, err
+	}
+	if reply.Err != "" {
+		return nil, nil, nil, errors.New(reply.Err)
+	}
+	f := codec.Lookup(reply.CodecType)
+	if f == nil {
+		return nil, nil, nil, fmt.Errorf("server picked unknown codec type %s", reply.CodecType)
+	}
+	o.CodecType = reply.CodecType
+	o.Compressor = reply.Compressor
+
+	cc := f(conn)
+	if c, ok := cc.(codec.Configurable); ok {
+		c.Configure(codec.Config{
+			MaxHeaderSize:     o.MaxHeaderSize,
+			MaxBodySize:       o.MaxBodySize,
+			Compressor:        o.Compressor,
+			CompressThreshold: o.CompressThreshold,
+		})
+	}
+	return cc, &o, &reply, nil
+}
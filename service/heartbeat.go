@@ -0,0 +1,132 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// 心跳: 双方按各自配置的间隔发送 ping 帧, 收到 ping 立即回复 pong
+// 心跳帧的 Seq 为 0, body 为空, 使用保留的 ServiceMethod, 不会与正常调用冲突
+// 连续 HeartbeatMisses 个间隔内没有收到任何帧 (包括正常的回复) 即认为连接已经失效,
+// 客户端以 ErrHeartbeatTimeout 结束所有 pending 的调用, 服务端直接关闭连接
+
+const (
+	pingMethod = "_geerpc.Ping"
+	pongMethod = "_geerpc.Pong"
+)
+
+// 允许错过的心跳个数
+const HeartbeatMisses = 3
+
+var ErrHeartbeatTimeout = &Error{Code: Unavailable, Message: "rpc: missed heartbeats, connection is dead"}
+
+func isHeartbeat(h *codec.Header) bool {
+	return h.Seq == 0 && (h.ServiceMethod == pingMethod || h.ServiceMethod == pongMethod)
+}
+
+// 处理收到的心跳帧, 返回 false 表示这不是心跳帧
+func handleHeartbeat(cc codec.Codec, h *codec.Header) (bool, error) {
+	if !isHeartbeat(h) {
+		return false, nil
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		return true, err
+	}
+	if h.ServiceMethod == pingMethod {
+		return true, sendHeartbeat(cc, pongMethod)
+	}
+	return true, nil
+}
+
+func sendHeartbeat(cc codec.Codec, method string) error {
+	return cc.Write(&codec.Header{ServiceMethod: method}, invalidRequest)
+}
+
+// 记录连接上最近一次收到帧的时间
+type liveness struct {
+	lastRecv int64 // UnixNano
+}
+
+func newLiveness() *liveness {
+	l := &liveness{}
+	l.touch()
+	return l
+}
+
+func (l *liveness) touch() { atomic.StoreInt64(&l.lastRecv, time.Now().UnixNano()) }
+
+func (l *liveness) silence() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&l.lastRecv))
+}
+
+// 按间隔发送 ping, 直到 stop 关闭; 超过 HeartbeatMisses 个间隔没有收到帧时调用 dead 并返回
+func (l *liveness) run(cc codec.Codec, interval time.Duration, logger *slog.Logger, stop <-chan struct{}, dead func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if l.silence() > interval*HeartbeatMisses {
+			dead()
+			return
+		}
+		if err := sendHeartbeat(cc, pingMethod); err != nil {
+			logger.Warn("rpc: send heartbeat failed", "err", err)
+		}
+	}
+}
+
+// 服务端连接的状态, 用于心跳检测和空闲超时
+type connState struct {
+	*liveness
+	active     int32     // 正在处理的调用数
+	lastActive int64     // 最近一次收到请求或调用结束的时间, UnixNano
+	goAwayAt   time.Time // 发送 goaway 的时间, 只在 server.mu 下访问, 见 shutdown.go
+}
+
+func newConnState() *connState {
+	return &connState{liveness: newLiveness(), lastActive: time.Now().UnixNano()}
+}
+
+func (s *connState) begin() {
+	atomic.AddInt32(&s.active, 1)
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *connState) end() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	atomic.AddInt32(&s.active, -1)
+}
+
+// 没有正在处理的调用, 且已经空闲了 d
+func (s *connState) idleFor(d time.Duration) bool {
+	return atomic.LoadInt32(&s.active) == 0 &&
+		time.Duration(time.Now().UnixNano()-atomic.LoadInt64(&s.lastActive)) >= d
+}
+
+// 空闲超时检查, 连接空闲超过 IdleTimeout 时关闭
+func (server *Server) watchIdle(cc codec.Codec, state *connState, stop <-chan struct{}) {
+	check := server.IdleTimeout / 4
+	if check < 10*time.Millisecond {
+		check = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if state.idleFor(server.IdleTimeout) {
+			server.logger().Info("rpc server: close idle connection", "idle_timeout", server.IdleTimeout)
+			_ = cc.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"GeeRPC/memconn"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_HeartbeatTimeout(t *testing.T) {
+	t.Parallel()
+	// 握手之后就不再读写, 模拟半开连接
+	l, err := memconn.Listen("", nil)
+	_assert(err == nil, "listen mem failed: %v", err)
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = NewServer().handshake(conn)
+	}()
+
+	opt := &Option{HeartbeatInterval: 20 * time.Millisecond}
+	client, err := Dial("mem", l.Addr().String(), opt)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	call := client.Go("Echo.Echo", "hello", &reply, make(chan *Call, 1))
+	select {
+	case call = <-call.Done:
+		_assert(errors.Is(call.Error, ErrHeartbeatTimeout), "expect ErrHeartbeatTimeout, got %v", call.Error)
+		_assert(CodeOf(call.Error) == Unavailable, "expect Unavailable, got %v", CodeOf(call.Error))
+	case <-time.After(2 * time.Second):
+		t.Fatal("pending call should be terminated after missed heartbeats")
+	}
+	_assert(!client.IsAvalable(), "client should be shut down")
+}
+
+func TestServer_HeartbeatTimeout(t *testing.T) {
+	t.Parallel()
+	server := &Server{HeartbeatInterval: 20 * time.Millisecond}
+	addr := startTestServer(t, "mem", server)
+
+	// 客户端握手后只读不回复 pong, 服务端应当关闭连接
+	conn, err := memconn.Dial(addr, time.Second)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = conn.Close() }()
+	cc, _, _, err := clientHandshake(conn, DefaultOption)
+	_assert(err == nil, "handshake failed: %v", err)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	pings := 0
+	for {
+		var h codec.Header
+		if err = cc.ReadHeader(&h); err != nil {
+			break
+		}
+		_assert(h.ServiceMethod == pingMethod, "expect ping, got %q", h.ServiceMethod)
+		_ = cc.ReadBody(nil)
+		pings++
+	}
+	var ne net.Error
+	_assert(!errors.As(err, &ne) || !ne.Timeout(), "server should close the connection, got %v", err)
+	_assert(pings > 0, "expect pings before close")
+}
+
+func TestHeartbeat_KeepAlive(t *testing.T) {
+	t.Parallel()
+	const interval = 10 * time.Millisecond
+	var e Echo
+	addr := startTestServer(t, "mem", &Server{HeartbeatInterval: interval}, &e)
+	client, err := Dial("mem", addr, &Option{HeartbeatInterval: interval})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 没有调用时心跳保持连接可用: 双方每个间隔各发一次 ping, 客户端每个间隔最多收到两个心跳帧,
+	// 收到 4*HeartbeatMisses 个之后空闲的时间已经超过了失效的时限
+	last, beats := atomic.LoadInt64(&client.live.lastRecv), 0
+	waitFor(t, func() bool {
+		_assert(client.IsAvalable(), "idle client should be kept alive by heartbeats")
+		if recv := atomic.LoadInt64(&client.live.lastRecv); recv != last {
+			last, beats = recv, beats+1
+		}
+		return beats >= 4*HeartbeatMisses
+	}, "expect heartbeats on an idle connection, got %d", beats)
+	var reply string
+	err = client.Call("Echo.Echo", "hello", &reply)
+	_assert(err == nil && reply == "hello", "call after idle heartbeats failed: %v", err)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	var e Echo
+	addr := startTestServer(t, "mem", &Server{IdleTimeout: 50 * time.Millisecond}, &e)
+	// 心跳不算作活动, 空闲的连接仍然会被关闭
+	client, err := Dial("mem", addr, &Option{HeartbeatInterval: 10 * time.Millisecond})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Echo.Echo", "hello", &reply)
+	_assert(err == nil, "call failed: %v", err)
+	waitFor(t, func() bool { return !client.IsAvalable() }, "idle connection should be closed by server")
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"GeeRPC/trace"
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// CallInfo 描述一次服务端调用, 原生调用和 HTTP 网关共用
+type CallInfo struct {
+	ServiceMethod string
+	Args          interface{} // 已解码的参数
+	Reply         interface{} // 回复, 调用成功后才有意义
+}
+
+// Handler 执行一次调用
+type Handler func(ctx context.Context, info *CallInfo) error
+
+// Interceptor 包裹一次调用, 可以在 next 前后做鉴权、统计等处理, 不调用 next 则直接返回错误
+type Interceptor func(ctx context.Context, info *CallInfo, next Handler) error
+
+// Use 添加拦截器, 先添加的在外层, 需要在 Accept 之前调用
+func (server *Server) Use(interceptors ...Interceptor) {
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// 一次已经找到方法并解码好参数的调用
+type invocation struct {
+	svc          *service
+	mtype        *methodType
+	argv, replyv reflect.Value
+}
+
+// invoke 是所有调用的公共路径: 统计和追踪 -> 拦截器 -> 超时控制 -> service.call
+// 返回的错误都带有错误码, 见 CodeOf
+func (server *Server) invoke(ctx context.Context, serviceMethod string, inv *invocation) error {
+	info := &CallInfo{
+		ServiceMethod: serviceMethod,
+		Args:          inv.argv.Interface(),
+		Reply:         inv.replyv.Interface(),
+	}
+	h := func(ctx context.Context, _ *CallInfo) error {
+		return server.callWithTimeout(ctx, inv)
+	}
+	for i := len(server.interceptors) - 1; i >= 0; i-- {
+		h = chainInterceptor(server.interceptors[i], h)
+	}
+	m := server.metrics()
+	inFlight := m.inFlight.With(serviceMethod)
+	inFlight.Inc()
+	defer inFlight.Dec()
+	var span *trace.Span
+	if server.Tracer != nil {
+		ctx, span = server.Tracer.Start(ctx, serviceMethod, trace.SpanKindServer)
+		setSpanAttributes(ctx, span, serviceMethod)
+	}
+	start := time.Now()
+	err := h(ctx, info)
+	m.observe(serviceMethod, err, time.Since(start))
+	if span != nil {
+		span.Finish(CodeOf(err).String(), err)
+	}
+	return err
+}
+
+func chainInterceptor(ic Interceptor, next Handler) Handler {
+	return func(ctx context.Context, info *CallInfo) error {
+		return ic(ctx, info, next)
+	}
+}
+
+// 服务端处理请求的时限, 原生调用和 HTTP 网关相同
+func (server *Server) handleTimeout() time.Duration {
+	switch {
+	case server.HandleTimeout > 0:
+		return server.HandleTimeout
+	case server.HandleTimeout < 0:
+		return 0
+	}
+	return DefaultOption.HandleTimeout
+}
+
+// 超时或取消后直接返回 DeadlineExceeded/Canceled, 同时取消传给方法的 ctx
+// Go 无法中止一个协程, 不接受 ctx 或者不检查 ctx 的方法会继续执行到结束, 结果被丢弃,
+// 这样的调用计入 methodType.abandoned; 超过 MaxAbandoned 时该方法的新调用直接被拒绝,
+// 避免下游变慢时协程越积越多
+func (server *Server) callWithTimeout(ctx context.Context, inv *invocation) error {
+	m := inv.mtype
+	if server.MaxAbandoned > 0 && m.NumAbandoned() >= int64(server.MaxAbandoned) {
+		return &Error{
+			Code:    Unavailable,
+			Message: fmt.Sprintf("rpc server: too many abandoned calls of %s still running", inv.svc.name+"."+m.method.Name),
+			Shed:    true,
+		}
+	}
+	timeout := server.handleTimeout()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	// 加一个buf, 防止超时后子协程阻塞在写通道
+	called := make(chan error, 1)
+	// 0: 执行中, 1: 已返回, 2: 已放弃; 谁先改变状态谁说了算
+	var state int32
+	go func() {
+		called <- inv.svc.callContext(ctx, m, inv.argv, inv.replyv)
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			atomic.AddInt64(&m.abandoned, -1)
+		}
+	}()
+	select {
+	case err := <-called:
+		return err
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			atomic.AddInt64(&m.abandoned, 1)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return Errorf(DeadlineExceeded, "rpc server: request handle timeout: expect within: %s", timeout)
+		}
+		return Errorf(Canceled, "rpc server: request canceled: %v", ctx.Err())
+	}
+}
+
+// NumAbandoned 返回所有方法已经超时或取消、但还在执行的调用数
+func (server *Server) NumAbandoned() int64 {
+	var n int64
+	server.serviceMap.Range(func(_, v interface{}) bool {
+		for _, m := range v.(*service).method {
+			n += m.NumAbandoned()
+		}
+		return true
+	})
+	return n
+}
+
+// span 的属性: 方法和对端地址, 状态在 Finish 时记录
+func setSpanAttributes(ctx context.Context, span *trace.Span, serviceMethod string) {
+	span.SetAttribute("rpc.system", "geerpc")
+	span.SetAttribute("rpc.method", serviceMethod)
+	if addr := peerAddrOf(ctx); addr != "" {
+		span.SetAttribute("net.peer.addr", addr)
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// JSON-RPC 2.0 网关, 让不使用 GeeRPC 客户端的调用方通过 HTTP 调用已注册的服务
+// 请求: {"jsonrpc": "2.0", "method": "Foo.Sum", "params": {"Num1": 1, "Num2": 2}, "id": 1}
+// params 可以是参数对象本身, 也可以是只包含参数的数组 (与 net/rpc/jsonrpc 相同)
+// 支持批量请求; 没有 id 的请求是通知, 不会有回复
+
+const jsonrpcVersion = "2.0"
+
+// JSON-RPC 2.0 规定的错误码
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000 // 服务方法返回的错误
+)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"-"`
+
+	isNotification bool
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// JSONRPCHandler 返回处理 JSON-RPC 2.0 请求的 http.Handler, 只接受 POST
+func (server *Server) JSONRPCHandler() http.Handler {
+	return http.HandlerFunc(server.serveJSONRPC)
+}
+
+func (server *Server) serveJSONRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 must POST", http.StatusMethodNotAllowed)
+		return
+	}
+	maxBody := int64(server.MaxBodySize)
+	if maxBody <= 0 {
+		maxBody = codec.DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, newJSONRPCError(jsonNull, JSONRPCInvalidRequest, err.Error()))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	ctx := withPeer(withHTTPTraceparent(r.Context(), r), &Peer{Addr: httpAddr(r.RemoteAddr)})
+	if len(body) > 0 && body[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			writeJSON(w, http.StatusOK, newJSONRPCError(jsonNull, JSONRPCParseError, err.Error()))
+			return
+		}
+		if len(raws) == 0 {
+			writeJSON(w, http.StatusOK, newJSONRPCError(jsonNull, JSONRPCInvalidRequest, "empty batch"))
+			return
+		}
+		resps := make([]*jsonrpcResponse, 0, len(raws))
+		for _, raw := range raws {
+			if resp := server.handleJSONRPC(ctx, raw); resp != nil {
+				resps = append(resps, resp)
+			}
+		}
+		// 全部是通知时不返回任何内容
+		if len(resps) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, resps)
+		return
+	}
+
+	if !json.Valid(body) {
+		writeJSON(w, http.StatusOK, newJSONRPCError(jsonNull, JSONRPCParseError, "invalid JSON"))
+		return
+	}
+	resp := server.handleJSONRPC(ctx, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// 处理一个请求对象, 通知返回 nil
+func (server *Server) handleJSONRPC(ctx context.Context, raw json.RawMessage) *jsonrpcResponse {
+	entry := server.newGatewayAccessEntry(ctx, "jsonrpc", time.Now(), len(raw))
+	req, id, err := parseJSONRPCRequest(raw)
+	if err != nil {
+		resp := newJSONRPCError(id, JSONRPCInvalidRequest, err.Error())
+		server.logJSONRPC(entry, "", resp, Errorf(InvalidArgument, "rpc gateway: %v", err))
+		return resp
+	}
+	resp, err := server.callJSONRPC(withAccessEntry(ctx, entry), req)
+	if req.isNotification {
+		server.logJSONRPC(entry, req.Method, nil, err)
+		return nil
+	}
+	server.logJSONRPC(entry, req.Method, resp, err)
+	return resp
+}
+
+// 记录一个请求对象的访问日志, resp 的字节数按单独编码计算, 通知没有回复
+func (server *Server) logJSONRPC(entry *accessEntry, method string, resp *jsonrpcResponse, err error) {
+	if entry == nil {
+		return
+	}
+	var size int
+	if resp != nil {
+		b, _ := json.Marshal(resp)
+		size = len(b)
+	}
+	server.logAccess(entry, &codec.Header{ServiceMethod: method}, response{size, err})
+}
+
+// 请求不合法时也返回能读出的 id, 错误回复需要带上它, 读不出时为 null
+func parseJSONRPCRequest(raw json.RawMessage) (*jsonrpcRequest, json.RawMessage, error) {
+	// 先解析成 map, 以区分 id 不存在 (通知) 和 id 为 null
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, jsonNull, errors.New("request must be a JSON object")
+	}
+	id, ok := fields["id"]
+	if ok && !isJSONRPCID(id) {
+		return nil, jsonNull, errors.New(`"id" must be a string, number or null`)
+	}
+	if !ok {
+		id = jsonNull
+	}
+	req := new(jsonrpcRequest)
+	if err := json.Unmarshal(raw, req); err != nil {
+		return nil, id, err
+	}
+	if req.Version != jsonrpcVersion {
+		return nil, id, errors.New(`"jsonrpc" must be "2.0"`)
+	}
+	if req.Method == "" {
+		return nil, id, errors.New(`"method" must be a non-empty string`)
+	}
+	req.ID = id
+	req.isNotification = !ok
+	return req, id, nil
+}
+
+// id 只能是字符串、数字或 null
+func isJSONRPCID(id json.RawMessage) bool {
+	switch v := bytes.TrimSpace(id); {
+	case len(v) == 0:
+		return false
+	case v[0] == '"', v[0] == '-', v[0] >= '0' && v[0] <= '9':
+		return true
+	default:
+		return string(v) == "null"
+	}
+}
+
+// 与原生调用走同一个调用路径, 拦截器和超时同样生效; 同时返回调用的错误, 用于访问日志
+func (server *Server) callJSONRPC(ctx context.Context, req *jsonrpcRequest) (*jsonrpcResponse, error) {
+	svc, mtype, err := server.findServiceDotMethod(req.Method)
+	if err != nil {
+		return newJSONRPCError(req.ID, JSONRPCMethodNotFound, err.Error()), err
+	}
+	inv := &invocation{svc: svc, mtype: mtype, argv: mtype.newArgv(), replyv: mtype.newReplyv()}
+	if err := decodeJSONRPCParams(req.Params, inv.argv); err != nil {
+		return newJSONRPCError(req.ID, JSONRPCInvalidParams, err.Error()), Errorf(InvalidArgument, "rpc gateway: decode params: %v", err)
+	}
+	if err := server.invoke(ctx, req.Method, inv); err != nil {
+		return newJSONRPCError(req.ID, jsonrpcCode(err), err.Error()), err
+	}
+	return &jsonrpcResponse{Version: jsonrpcVersion, Result: inv.replyv.Interface(), ID: req.ID}, nil
+}
+
+// 将错误码映射为 JSON-RPC 错误码
+func jsonrpcCode(err error) int {
+	switch CodeOf(err) {
+	case Unimplemented:
+		return JSONRPCMethodNotFound
+	case InvalidArgument:
+		return JSONRPCInvalidParams
+	case Internal:
+		return JSONRPCInternalError
+	default:
+		return JSONRPCServerError
+	}
+}
+
+// params 为空时使用参数的零值; 参数类型本身不是数组或切片时, 只包含一个元素的数组视为参数本身
+func decodeJSONRPCParams(params json.RawMessage, argv reflect.Value) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, jsonNull) {
+		return nil
+	}
+	argvi := argvPointer(argv)
+	if params[0] == '[' {
+		kind := reflect.TypeOf(argvi).Elem().Kind()
+		if kind != reflect.Slice && kind != reflect.Array {
+			var list []json.RawMessage
+			if err := json.Unmarshal(params, &list); err != nil {
+				return err
+			}
+			if len(list) != 1 {
+				return errors.New("positional params must contain exactly one element")
+			}
+			params = list[0]
+		}
+	}
+	return json.Unmarshal(params, argvi)
+}
+
+func newJSONRPCError(id json.RawMessage, code int, msg string) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: jsonrpcVersion, Error: &jsonrpcError{Code: code, Message: msg}, ID: id}
+}
+
+// 返回写出的 body 字节数
+func writeJSON(w http.ResponseWriter, status int, v interface{}) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	cw := &countingWriter{w: w}
+	_ = json.NewEncoder(cw).Encode(v)
+	return cw.n
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"GeeRPC/foo"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postJSONRPC(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestServer_JSONRPC(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var f foo.Foo
+	_ = server.Register(&f)
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	defer ts.Close()
+
+	cases := []struct {
+		name, req, resp string
+	}{
+		{"named params", `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"positional params", `{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":2,"Num2":2}],"id":"a"}`,
+			`{"jsonrpc":"2.0","result":4,"id":"a"}`},
+		{"unknown method", `{"jsonrpc":"2.0","method":"Foo.sum","id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc server: can't find method sum"},"id":2}`},
+		{"invalid params", `{"jsonrpc":"2.0","method":"Foo.Sum","params":"x","id":3}`, `"code":-32602`},
+		{"parse error", `{"jsonrpc":"2.0",`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"invalid JSON"},"id":null}`},
+		{"invalid request", `{"jsonrpc":"1.0","method":"Foo.Sum","id":4}`, `"code":-32600`},
+		// 请求不合法但 id 可读时回复要带上 id
+		{"invalid version keeps id", `{"jsonrpc":"1.0","method":"Foo.Sum","id":4}`, `"id":4}`},
+		{"empty method keeps id", `{"jsonrpc":"2.0","method":"","id":"b"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"\"method\" must be a non-empty string"},"id":"b"}`},
+		{"invalid id", `{"jsonrpc":"2.0","method":"Foo.Sum","id":{}}`, `"id":null}`},
+		{"batch", `[{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1},"id":1},{"jsonrpc":"2.0","method":"Foo.Sum"},1]`,
+			`[{"jsonrpc":"2.0","result":1,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"request must be a JSON object"},"id":null}]`},
+		{"empty batch", `[]`, `"code":-32600`},
+	}
+	for _, c := range cases {
+		status, resp := postJSONRPC(t, ts.URL, c.req)
+		_assert(status == http.StatusOK && strings.Contains(resp, c.resp), "%s: got %d %s", c.name, status, resp)
+		_assert(json.Valid([]byte(resp)), "%s: response is not valid JSON", c.name)
+	}
+
+	// 通知没有回复
+	status, resp := postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}`)
+	_assert(status == http.StatusNoContent && resp == "", "notification should have no response, got %d %s", status, resp)
+}
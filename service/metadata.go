@@ -0,0 +1,64 @@
+package service
+
+import (
+	"GeeRPC/trace"
+	"context"
+	"net/http"
+)
+
+// 请求 metadata 随 header 发送, 用于传递鉴权信息、追踪上下文等与参数无关的内容
+// 客户端通过 WithMetadata 设置, 服务方法和拦截器通过 MetadataFromContext 读取
+// trace.TraceparentKey 由框架使用, 客户端有 Tracer 或者 ctx 中有 span 时会被覆盖
+
+// WithMetadata 设置调用的 metadata, 多次调用时合并
+func WithMetadata(md map[string]string) CallOption {
+	return func(call *Call) {
+		for k, v := range md {
+			setMetadata(call, k, v)
+		}
+	}
+}
+
+// 不修改调用方传入的 map
+func setMetadata(call *Call, key, value string) {
+	md := make(map[string]string, len(call.Metadata)+1)
+	for k, v := range call.Metadata {
+		md[k] = v
+	}
+	md[key] = value
+	call.Metadata = md
+}
+
+type metadataKey struct{}
+
+// MetadataFromContext 返回请求 header 中的 metadata, 不能修改
+func MetadataFromContext(ctx context.Context) (map[string]string, bool) {
+	md, ok := ctx.Value(metadataKey{}).(map[string]string)
+	return md, ok
+}
+
+// 记录请求的 metadata, 其中的 traceparent 作为服务端 span 的父 span
+func withMetadata(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, metadataKey{}, md)
+	return withTraceparent(ctx, md[trace.TraceparentKey])
+}
+
+// HTTP 网关从 traceparent header 中取出追踪上下文
+func withHTTPTraceparent(ctx context.Context, r *http.Request) context.Context {
+	return withTraceparent(ctx, r.Header.Get(trace.TraceparentKey))
+}
+
+// 格式不对的 traceparent 直接忽略, 与规范一致
+func withTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, err := trace.ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemote(ctx, sc)
+}
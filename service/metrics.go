@@ -0,0 +1,248 @@
+package service
+
+import (
+	"GeeRPC/metrics"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务端和客户端的指标, 以 Prometheus 文本格式输出:
+//
+//	http.Handle(service.DefaultMetricsPath, server.MetricsHandler())
+//
+// Server 不会自己挂载 /metrics, 需要时由程序显式挂载, app 把它挂在 debug_addr 上
+// 服务端的调用指标在 invoke 中统计, 原生调用和 HTTP 网关都会计入; 连接和字节数只统计原生连接 (包括 WebSocket)
+// 客户端的指标按目标地址 (network@addr) 区分, 所有客户端共享, 只统计通过 Dial/NewClient 建立的客户端;
+// 一个目标的连接都关闭、调用都结束后删除它的序列, 同时有指标的目标超过 maxClientTargets 个时
+// 新的目标归入 target="other", 服务发现频繁变化时标签的个数不会无限增长
+
+// /metrics 的默认路径
+const DefaultMetricsPath = "/metrics"
+
+// 调用不存在的方法时的 method 标签, 避免任意的方法名撑大指标
+const unknownMethod = "unknown"
+
+type serverMetrics struct {
+	reg               *metrics.Registry
+	calls             *metrics.CounterVec
+	errors            *metrics.CounterVec
+	latency           *metrics.HistogramVec
+	inFlight          *metrics.GaugeVec
+	bytesIn, bytesOut *metrics.Counter
+	conns             *metrics.Gauge
+	connsTotal        *metrics.Counter
+	handshakeFailures *metrics.Counter
+}
+
+func (server *Server) metrics() *serverMetrics {
+	server.metricsOnce.Do(func() {
+		reg := metrics.NewRegistry()
+		m := &serverMetrics{
+			reg:      reg,
+			calls:    reg.NewCounter("geerpc_server_calls_total", "Calls received, by method.", "method"),
+			errors:   reg.NewCounter("geerpc_server_errors_total", "Calls failed, by method and status code.", "method", "code"),
+			latency:  reg.NewHistogram("geerpc_server_call_duration_seconds", "Time spent handling calls, by method.", nil, "method"),
+			inFlight: reg.NewGauge("geerpc_server_in_flight", "Calls being handled, by method.", "method"),
+		}
+		m.bytesIn = reg.NewCounter("geerpc_server_received_bytes_total", "Bytes read from native connections.").With()
+		m.bytesOut = reg.NewCounter("geerpc_server_sent_bytes_total", "Bytes written to native connections.").With()
+		m.conns = reg.NewGauge("geerpc_server_connections", "Open native connections.").With()
+		m.connsTotal = reg.NewCounter("geerpc_server_connections_total", "Native connections accepted.").With()
+		m.handshakeFailures = reg.NewCounter("geerpc_server_handshake_failures_total", "Connections closed because the handshake failed.").With()
+		reg.NewGaugeFunc("geerpc_server_queued", "Calls waiting in the admission queue.", func() float64 {
+			return float64(server.scheduler().queued())
+		})
+		reg.NewGaugeFunc("geerpc_server_abandoned", "Timed-out or canceled calls still running.", func() float64 {
+			return float64(server.NumAbandoned())
+		})
+		server.stats = m
+	})
+	return server.stats
+}
+
+// 记录一次执行完的调用
+func (m *serverMetrics) observe(method string, err error, d time.Duration) {
+	m.calls.With(method).Inc()
+	m.latency.With(method).Observe(d.Seconds())
+	if err != nil {
+		m.errors.With(method, CodeOf(err).String()).Inc()
+	}
+}
+
+// 记录一次没有执行就回复了错误的调用
+func (m *serverMetrics) rejected(method string, err error) {
+	m.calls.With(method).Inc()
+	m.errors.With(method, CodeOf(err).String()).Inc()
+}
+
+// 统计连接上读写的字节数
+func (m *serverMetrics) countConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &countedConn{ReadWriteCloser: conn, in: m.bytesIn, out: m.bytesOut}
+}
+
+// MetricsHandler 返回输出指标的 http.Handler, 同时包含本进程中客户端的指标
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = metrics.Write(w, server.metrics().reg, clientStats.reg)
+	})
+}
+
+// ClientMetricsHandler 返回只包含客户端指标的 http.Handler, 用于不提供服务的进程
+func ClientMetricsHandler() http.Handler {
+	return clientStats.reg
+}
+
+type countedConn struct {
+	io.ReadWriteCloser
+	in, out *metrics.Counter
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.in.Add(uint64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.out.Add(uint64(n))
+	return n, err
+}
+
+// 客户端需要保留 net.Conn 的其他方法
+type countedNetConn struct {
+	net.Conn
+	in, out *metrics.Counter
+}
+
+func (c *countedNetConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(uint64(n))
+	return n, err
+}
+
+func (c *countedNetConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(uint64(n))
+	return n, err
+}
+
+type clientMetrics struct {
+	reg               *metrics.Registry
+	calls             *metrics.CounterVec
+	errors            *metrics.CounterVec
+	latency           *metrics.HistogramVec
+	inFlight          *metrics.GaugeVec
+	bytesIn, bytesOut *metrics.CounterVec
+	conns             *metrics.GaugeVec
+	handshakeFailures *metrics.Counter // 不区分目标, 握手失败的目标没有其他指标
+
+	mu      sync.Mutex
+	targets map[string]*targetMetrics
+}
+
+const (
+	maxClientTargets = 1000
+	otherTarget      = "other"
+)
+
+// 所有客户端共享一组指标
+var clientStats = newClientMetrics()
+
+func newClientMetrics() *clientMetrics {
+	reg := metrics.NewRegistry()
+	return &clientMetrics{
+		reg:               reg,
+		calls:             reg.NewCounter("geerpc_client_calls_total", "Calls sent, by target and method.", "target", "method"),
+		errors:            reg.NewCounter("geerpc_client_errors_total", "Calls failed, by target, method and status code.", "target", "method", "code"),
+		latency:           reg.NewHistogram("geerpc_client_call_duration_seconds", "Time from sending a call to its completion, by target and method.", nil, "target", "method"),
+		inFlight:          reg.NewGauge("geerpc_client_in_flight", "Calls waiting for a reply, by target.", "target"),
+		bytesIn:           reg.NewCounter("geerpc_client_received_bytes_total", "Bytes read, by target.", "target"),
+		bytesOut:          reg.NewCounter("geerpc_client_sent_bytes_total", "Bytes written, by target.", "target"),
+		conns:             reg.NewGauge("geerpc_client_connections", "Open connections, by target.", "target"),
+		handshakeFailures: reg.NewCounter("geerpc_client_handshake_failures_total", "Handshakes failed.").With(),
+		targets:           make(map[string]*targetMetrics),
+	}
+}
+
+// 一个目标地址的指标, 同一目标的客户端共享
+type targetMetrics struct {
+	target   string
+	refs     int64 // 打开的连接数加上进行中的调用数, 降为 0 时删除这个目标的序列
+	inFlight *metrics.Gauge
+	conns    *metrics.Gauge
+}
+
+// 新连接开始使用目标的指标, 结束时必须调用 release
+func (m *clientMetrics) acquire(target string) *targetMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[target]
+	if t == nil && len(m.targets) >= maxClientTargets {
+		target = otherTarget
+		t = m.targets[target]
+	}
+	if t == nil {
+		t = &targetMetrics{target: target, inFlight: m.inFlight.With(target), conns: m.conns.With(target)}
+		m.targets[target] = t
+	}
+	atomic.AddInt64(&t.refs, 1)
+	return t
+}
+
+// 最后一个引用释放时删除目标的序列; 此后同一目标的新连接会重新创建
+func (m *clientMetrics) release(t *targetMetrics) {
+	if atomic.AddInt64(&t.refs, -1) > 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 等锁期间可能又被 acquire
+	if atomic.LoadInt64(&t.refs) > 0 || m.targets[t.target] != t {
+		return
+	}
+	delete(m.targets, t.target)
+	m.calls.DeletePartial("target", t.target)
+	m.errors.DeletePartial("target", t.target)
+	m.latency.DeletePartial("target", t.target)
+	m.inFlight.DeletePartial("target", t.target)
+	m.bytesIn.DeletePartial("target", t.target)
+	m.bytesOut.DeletePartial("target", t.target)
+	m.conns.DeletePartial("target", t.target)
+}
+
+func (m *clientMetrics) countConn(t *targetMetrics, conn net.Conn) net.Conn {
+	return &countedNetConn{Conn: conn, in: m.bytesIn.With(t.target), out: m.bytesOut.With(t.target)}
+}
+
+// 目标地址, 与 XDial 的格式相同
+func targetOf(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return "unknown"
+	}
+	return addr.Network() + "@" + addr.String()
+}
+
+// 调用发出
+func (t *targetMetrics) begin(call *Call) {
+	atomic.AddInt64(&t.refs, 1)
+	call.stats = t
+	call.start = time.Now()
+	t.inFlight.Inc()
+}
+
+// 调用结束, 结果在 call.Error 中
+func (t *targetMetrics) end(call *Call) {
+	t.inFlight.Dec()
+	clientStats.calls.With(t.target, call.ServiceMethod).Inc()
+	clientStats.latency.With(t.target, call.ServiceMethod).Observe(time.Since(call.start).Seconds())
+	if call.Error != nil {
+		clientStats.errors.With(t.target, call.ServiceMethod, CodeOf(call.Error).String()).Inc()
+	}
+	clientStats.release(t)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, server *Server) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	return rec.Body.String()
+}
+
+func TestServer_Metrics(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	addr := startTestServer(t, "mem", server, new(Echo))
+	client, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	_assert(client.Call("Echo.Echo", "hi", &reply) == nil, "call failed")
+	_assert(client.Call("Echo.Missing", "hi", &reply) != nil, "expect unknown method to fail")
+
+	body := scrape(t, server)
+	target := "mem@" + addr
+	for _, want := range []string{
+		`geerpc_server_calls_total{method="Echo.Echo"} 1`,
+		`geerpc_server_calls_total{method="unknown"} 1`,
+		`geerpc_server_errors_total{method="unknown",code="Unimplemented"} 1`,
+		`geerpc_server_call_duration_seconds_count{method="Echo.Echo"} 1`,
+		`geerpc_server_in_flight{method="Echo.Echo"} 0`,
+		"geerpc_server_connections 1\n",
+		"geerpc_server_connections_total 1\n",
+		"geerpc_server_handshake_failures_total 0\n",
+		`geerpc_client_calls_total{target="` + target + `",method="Echo.Echo"} 1`,
+		`geerpc_client_errors_total{target="` + target + `",method="Echo.Missing",code="Unimplemented"} 1`,
+		`geerpc_client_in_flight{target="` + target + `"} 0`,
+		`geerpc_client_connections{target="` + target + `"} 1`,
+	} {
+		_assert(strings.Contains(body, want), "metrics missing %q:\n%s", want, body)
+	}
+	_assert(!strings.Contains(body, "geerpc_server_received_bytes_total 0\n"), "expect bytes received to be counted")
+	_assert(!strings.Contains(body, `geerpc_client_sent_bytes_total{target="`+target+`"} 0`), "expect client bytes sent to be counted")
+}
+
+func TestClientMetrics_DropClosedTarget(t *testing.T) {
+	t.Parallel()
+	addr := startTestServer(t, "mem", NewServer(), new(Echo))
+	client, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	var reply string
+	_assert(client.Call("Echo.Echo", "hi", &reply) == nil, "call failed")
+
+	target := `target="mem@` + addr + `"`
+	scrapeClient := func() string {
+		rec := httptest.NewRecorder()
+		ClientMetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+		return rec.Body.String()
+	}
+	_assert(strings.Contains(scrapeClient(), target), "expect series of open target")
+	_ = client.Close()
+	// receive 退出后才释放
+	for deadline := time.Now().Add(time.Second); strings.Contains(scrapeClient(), target) && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_assert(!strings.Contains(scrapeClient(), target), "expect series of closed target to be dropped:\n%s", scrapeClient())
+}
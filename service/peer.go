@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Ucred 是 unix socket 对端进程的身份, 由内核 (SO_PEERCRED) 提供, 不能伪造
+type Ucred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Peer 描述调用方, 服务方法可以通过 PeerFromContext 获取
+type Peer struct {
+	Addr net.Addr
+	Cred *Ucred // 只有 unix socket 连接才有, 其他连接为 nil
+}
+
+type peerKey struct{}
+
+// PeerFromContext 返回发起本次调用的对端信息
+// 接收 context.Context 作为第一个参数的服务方法可以据此做鉴权, 例如只允许 root 调用管理接口:
+//
+//	func (a *Admin) Reload(ctx context.Context, args Args, reply *Reply) error {
+//		if p, ok := service.PeerFromContext(ctx); !ok || p.Cred == nil || p.Cred.UID != 0 {
+//			return service.Errorf(service.PermissionDenied, "admin: root only")
+//		}
+//		...
+//	}
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+type principalKey struct{}
+
+// WithPrincipal 记录鉴权得到的调用方身份, 由鉴权拦截器在调用 next 前设置:
+//
+//	server.Use(func(ctx context.Context, info *service.CallInfo, next service.Handler) error {
+//		user, err := authenticate(ctx)
+//		if err != nil {
+//			return service.Errorf(service.Unauthenticated, "%v", err)
+//		}
+//		return next(service.WithPrincipal(ctx, user), info)
+//	})
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	// 同时记入访问日志
+	if e, ok := ctx.Value(accessKey{}).(*accessEntry); ok {
+		e.setPrincipal(principal)
+	}
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext 返回 WithPrincipal 设置的调用方身份
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalKey{}).(string)
+	return p, ok
+}
+
+// 根据连接获取对端信息, 不是 net.Conn 的连接返回 nil
+// 拿不到 unix socket 对端身份时仍然返回 Peer, 同时返回错误
+func peerOf(conn interface{}) (*Peer, error) {
+	nc, ok := conn.(net.Conn)
+	if !ok {
+		return nil, nil
+	}
+	p := &Peer{Addr: nc.RemoteAddr()}
+	// unix socket 上的 TLS 连接, 身份要从底层的连接读取
+	if tc, ok := nc.(*tls.Conn); ok {
+		nc = tc.NetConn()
+	}
+	var err error
+	if uc, ok := nc.(*net.UnixConn); ok {
+		p.Cred, err = peerCred(uc)
+	}
+	return p, err
+}
+
+// 对端地址, 格式与 XDial 相同, 用于日志
+func peerAddr(p *Peer) string {
+	if p == nil || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.Network() + "@" + p.Addr.String()
+}
+
+func peerAddrOf(ctx context.Context) string {
+	p, _ := PeerFromContext(ctx)
+	return peerAddr(p)
+}
+
+// HTTP 网关的对端地址
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// Listen 与 net.Listen 相同, 对 unix socket 额外处理:
+// 文件路径上残留的 socket 文件 (上次进程异常退出留下的) 会被删除后重新监听,
+// 仍有进程在监听时返回错误; 以 @ 开头的地址是 Linux 的 abstract socket, 不对应文件
+func Listen(network, address string) (net.Listener, error) {
+	if (network == "unix" || network == "unixpacket") && !strings.HasPrefix(address, "@") {
+		if err := removeStaleSocket(network, address); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(network, address)
+}
+
+func removeStaleSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("rpc server: %s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("rpc server: %s is already in use", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(path)
+}
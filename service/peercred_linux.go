@@ -0,0 +1,28 @@
+//go:build linux
+
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+// 通过 SO_PEERCRED 获取 unix socket 对端进程的身份
+func peerCred(conn *net.UnixConn) (*Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &Ucred{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
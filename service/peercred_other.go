@@ -0,0 +1,13 @@
+//go:build !linux
+
+package service
+
+import (
+	"errors"
+	"net"
+)
+
+// 其他平台暂不支持获取对端身份
+func peerCred(conn *net.UnixConn) (*Ucred, error) {
+	return nil, errors.New("peer credentials are only supported on linux")
+}
@@ -0,0 +1,42 @@
+package service
+
+import "strconv"
+
+// Priority 是请求的优先级, 随请求 header 发送
+// 服务端排队时先处理优先级高的请求, 队列满时先丢弃优先级低的请求, 见 admission.go
+// 服务端没有设置 MaxInFlight 或 MaxConnInFlight 时请求不排队, 优先级不起作用
+type Priority uint8
+
+const (
+	PriorityLow    Priority = 1 // 批处理等可以等待的请求
+	PriorityNormal Priority = 2 // 默认
+	PriorityHigh   Priority = 3 // 交互式请求
+)
+
+// header 中的 0 表示客户端没有设置, 按 PriorityNormal 处理
+func priorityOf(p uint8) Priority {
+	if p == 0 {
+		return PriorityNormal
+	}
+	return Priority(p)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return "priority(" + strconv.Itoa(int(p)) + ")"
+}
+
+// CallOption 设置单次调用的参数
+type CallOption func(*Call)
+
+// WithPriority 设置调用的优先级
+func WithPriority(p Priority) CallOption {
+	return func(call *Call) { call.Priority = p }
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流, 以拦截器的形式使用, 原生调用和 HTTP 网关都会经过:
+//
+//	limiter := &service.RateLimiter{
+//		Methods: map[string]service.Limit{"Foo.Sum": {Rate: 100, Burst: 20}},
+//		Caller:  service.Limit{Rate: 10, Burst: 10},
+//	}
+//	server.Use(auth, limiter.Interceptor())
+//
+// 超过限制的调用直接返回 ResourceExhausted, 并在 Error.RetryAfter 中给出还需要等待的时间
+
+// Limit 是令牌桶的参数: 每秒补充 Rate 个令牌, 最多积攒 Burst 个, 每次调用消耗一个
+// Rate 为 0 表示不限制, Burst 小于 1 时按 max(1, Rate) 处理
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst >= 1 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+type RateLimiter struct {
+	Methods       map[string]Limit // 每个方法 "Service.Method" 的速率, 所有调用方共享
+	Caller        Limit            // 每个调用方的速率, 所有方法合计
+	CallerMethods map[string]Limit // 每个调用方调用某个方法的速率
+	// 区分调用方, 默认为 CallerKey
+	Key func(ctx context.Context) string
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// 长时间不用的桶会被清理
+const bucketSweepInterval = time.Minute
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// 按时间补充令牌, 必须持有锁
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if max := b.limit.burst(); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// 还需要等待多久才有一个令牌
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// CallerKey 是默认的调用方标识: 鉴权得到的身份 (WithPrincipal), 其次是 unix socket 对端的 uid,
+// 最后是对端的 IP (不含端口, 同一台机器的多个连接共享限额)
+func CallerKey(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok && principal != "" {
+		return "principal:" + principal
+	}
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	if p.Cred != nil {
+		return fmt.Sprintf("uid:%d", p.Cred.UID)
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return p.Addr.Network() + ":" + addr
+}
+
+// Interceptor 返回执行限流的拦截器, 需要放在设置调用方身份的拦截器之后
+func (rl *RateLimiter) Interceptor() Interceptor {
+	return func(ctx context.Context, info *CallInfo, next Handler) error {
+		if err := rl.Allow(ctx, info.ServiceMethod); err != nil {
+			return err
+		}
+		return next(ctx, info)
+	}
+}
+
+// Allow 检查一次调用是否超过限制, 没有超过时消耗令牌并返回 nil
+// 只要有一个桶没有令牌, 所有桶都不消耗
+func (rl *RateLimiter) Allow(ctx context.Context, serviceMethod string) error {
+	type check struct {
+		key   string
+		limit Limit
+	}
+	var checks []check
+	if l, ok := rl.Methods[serviceMethod]; ok && l.Rate > 0 {
+		checks = append(checks, check{"method|" + serviceMethod, l})
+	}
+	caller := ""
+	if rl.Caller.Rate > 0 || len(rl.CallerMethods) > 0 {
+		keyFunc := rl.Key
+		if keyFunc == nil {
+			keyFunc = CallerKey
+		}
+		caller = keyFunc(ctx)
+	}
+	if rl.Caller.Rate > 0 {
+		checks = append(checks, check{"caller|" + caller, rl.Caller})
+	}
+	if l, ok := rl.CallerMethods[serviceMethod]; ok && l.Rate > 0 {
+		checks = append(checks, check{"caller-method|" + caller + "|" + serviceMethod, l})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.sweep(now)
+	buckets := make([]*bucket, len(checks))
+	var wait time.Duration
+	for i, c := range checks {
+		b := rl.buckets[c.key]
+		if b == nil {
+			b = &bucket{limit: c.limit, tokens: c.limit.burst(), last: now}
+			rl.buckets[c.key] = b
+		}
+		b.refill(now)
+		if w := b.wait(); w > wait {
+			wait = w
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return &Error{
+			Code:       ResourceExhausted,
+			Message:    fmt.Sprintf("rpc server: rate limit exceeded for %s, retry after %s", serviceMethod, wait),
+			RetryAfter: wait,
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil
+}
+
+// 清理已经补满的桶, 它们和新建的桶没有区别, 必须持有锁
+func (rl *RateLimiter) sweep(now time.Time) {
+	if rl.buckets == nil {
+		rl.buckets = make(map[string]*bucket)
+		rl.lastSweep = now
+		return
+	}
+	if now.Sub(rl.lastSweep) < bucketSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		b.refill(now)
+		if b.tokens >= b.limit.burst() {
+			delete(rl.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_RateLimit(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	limiter := &RateLimiter{Methods: map[string]Limit{"Echo.Echo": {Rate: 1, Burst: 2}}}
+	server.Use(limiter.Interceptor())
+	var e Echo
+	client, err := Dial("mem", startTestServer(t, "mem", server, &e))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	for i := 0; i < 2; i++ {
+		err = client.Call("Echo.Echo", "hi", &reply)
+		_assert(err == nil, "call within burst failed: %v", err)
+	}
+	err = client.Call("Echo.Echo", "hi", &reply)
+	_assert(CodeOf(err) == ResourceExhausted, "expect ResourceExhausted, got %v", err)
+	d := RetryAfterOf(err)
+	_assert(d > 0 && d <= time.Second, "expect retry-after within 1s, got %s", d)
+
+	// REST 网关回复 429 和 Retry-After
+	ts := httptest.NewServer(server.RESTHandler())
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/rpc/Echo/Echo", "application/json", strings.NewReader(`"hi"`))
+	_assert(err == nil, "post failed: %v", err)
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "1",
+		"expect 429 with Retry-After 1, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+}
+
+func TestRateLimiter_PerCaller(t *testing.T) {
+	t.Parallel()
+	limiter := &RateLimiter{
+		Caller:        Limit{Rate: 1, Burst: 1},
+		CallerMethods: map[string]Limit{"Echo.Echo": {Rate: 1, Burst: 1}},
+	}
+	alice := WithPrincipal(context.Background(), "alice")
+	bob := WithPrincipal(context.Background(), "bob")
+
+	_assert(limiter.Allow(alice, "Foo.Sum") == nil, "first call of alice should pass")
+	_assert(CodeOf(limiter.Allow(alice, "Foo.Sum")) == ResourceExhausted, "alice should be limited")
+	// 每个调用方有各自的桶
+	_assert(limiter.Allow(bob, "Echo.Echo") == nil, "bob should not share alice's bucket")
+	_assert(CodeOf(limiter.Allow(bob, "Foo.Sum")) == ResourceExhausted, "bob's caller bucket should be empty")
+
+	// 没有鉴权身份时按对端地址区分, 端口不同的连接共享限额
+	peer := func(addr string) context.Context {
+		a, _ := net.ResolveTCPAddr("tcp", addr)
+		return withPeer(context.Background(), &Peer{Addr: a})
+	}
+	_assert(limiter.Allow(peer("10.0.0.1:1000"), "Foo.Sum") == nil, "first call from 10.0.0.1 should pass")
+	_assert(limiter.Allow(peer("10.0.0.1:2000"), "Foo.Sum") != nil, "same host should share the bucket")
+	_assert(limiter.Allow(peer("10.0.0.2:1000"), "Foo.Sum") == nil, "another host has its own bucket")
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// 反射服务: 每个服务端都内置的 "_Reflection" 服务, 不需要注册, 让通用工具不依赖生成的代码就能发现和调用方法
+//
+//	var list service.ListReply
+//	_ = client.Call(service.ReflectionList, service.ListArgs{}, &list)
+//	var desc service.ServiceDesc
+//	_ = client.Call(service.ReflectionDescribe, service.DescribeArgs{Name: "Foo.Sum"}, &desc)
+//
+// 参数和回复描述的是线上的形状: 去掉了最外层的指针
+// 参数和回复不是 proto.Message, 服务端只接受 protobuf 时不可用, 调用返回 Unimplemented
+
+const (
+	ReflectionService  = "_Reflection"
+	ReflectionList     = ReflectionService + ".List"
+	ReflectionDescribe = ReflectionService + ".Describe"
+)
+
+// ListArgs 为空时列出所有服务
+type ListArgs struct {
+	Service string // 只列出这个服务
+}
+
+type ListReply struct {
+	Services []ServiceInfo
+}
+
+// ServiceInfo 是服务的名字和方法名, 按名字排序
+type ServiceInfo struct {
+	Name    string
+	Methods []string
+}
+
+// DescribeArgs.Name 为 "Service" 时描述所有方法, 为 "Service.Method" 时只描述一个方法
+type DescribeArgs struct {
+	Name string
+}
+
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc
+}
+
+type MethodDesc struct {
+	Name       string // 方法名, 不含服务名
+	Arg        *TypeDesc
+	Reply      *TypeDesc
+	Idempotent bool // 见 IdempotentMethods
+}
+
+// TypeDesc 描述一个类型, 嵌套的类型直接展开
+type TypeDesc struct {
+	Name      string      `json:",omitempty"` // 具名类型的名字, 如 foo.Args, 内置类型为 int、string 等
+	Kind      string      // reflect.Kind 的名字: int, string, struct, slice, map, ptr 等
+	Fields    []FieldDesc `json:",omitempty"` // struct 的导出字段
+	Elem      *TypeDesc   `json:",omitempty"` // ptr, slice, array, map 的元素类型
+	Key       *TypeDesc   `json:",omitempty"` // map 的键类型
+	Len       int         `json:",omitempty"` // array 的长度
+	Recursive bool        `json:",omitempty"` // 外层已经展开过这个类型, 不再重复展开
+}
+
+type FieldDesc struct {
+	Name     string // Go 字段名
+	JSONName string // JSON 编码时的键, 由 json tag 决定
+	Type     *TypeDesc
+	Embedded bool `json:",omitempty"` // 是否为嵌入字段
+}
+
+type reflectionService struct {
+	server *Server
+}
+
+// 反射服务的方法都是只读的
+func (r *reflectionService) IdempotentMethods() []string {
+	return []string{"List", "Describe"}
+}
+
+// 第一次调用时创建, &Server{} 字面量也可以使用; 服务端只接受 protobuf 时返回错误
+func (server *Server) reflectionService() (*service, error) {
+	if server.protobufOnly() {
+		return nil, Errorf(Unimplemented, "rpc server: %s is not available when the server only accepts protobuf", ReflectionService)
+	}
+	server.reflectionOnce.Do(func() {
+		server.reflection = newNamedService(ReflectionService, &reflectionService{server})
+	})
+	return server.reflection, nil
+}
+
+// 服务名以 "_" 开头的内部服务不列出
+func (r *reflectionService) List(args ListArgs, reply *ListReply) error {
+	r.server.serviceMap.Range(func(_, v interface{}) bool {
+		svc := v.(*service)
+		if strings.HasPrefix(svc.name, "_") || args.Service != "" && svc.name != args.Service {
+			return true
+		}
+		reply.Services = append(reply.Services, ServiceInfo{Name: svc.name, Methods: sortedKeys(svc.method)})
+		return true
+	})
+	if args.Service != "" && len(reply.Services) == 0 {
+		return Errorf(NotFound, "rpc reflection: can't find service %s", args.Service)
+	}
+	sort.Slice(reply.Services, func(i, j int) bool { return reply.Services[i].Name < reply.Services[j].Name })
+	return nil
+}
+
+func (r *reflectionService) Describe(args DescribeArgs, reply *ServiceDesc) error {
+	serviceName, methodName := args.Name, ""
+	if dot := strings.LastIndex(args.Name, "."); dot >= 0 {
+		serviceName, methodName = args.Name[:dot], args.Name[dot+1:]
+	}
+	v, ok := r.server.serviceMap.Load(serviceName)
+	if !ok {
+		return Errorf(NotFound, "rpc reflection: can't find service %s", serviceName)
+	}
+	svc := v.(*service)
+	names := sortedKeys(svc.method)
+	if methodName != "" {
+		if svc.method[methodName] == nil {
+			return Errorf(NotFound, "rpc reflection: can't find method %s", args.Name)
+		}
+		names = []string{methodName}
+	}
+	reply.Name = svc.name
+	for _, name := range names {
+		m := svc.method[name]
+		reply.Methods = append(reply.Methods, MethodDesc{
+			Name:       name,
+			Arg:        describeType(indirectType(m.ArgType), nil),
+			Reply:      describeType(indirectType(m.ReplyType), nil),
+			Idempotent: m.idempotent,
+		})
+	}
+	return nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// seen 是外层正在展开的具名 struct, 用来截断递归类型
+func describeType(t reflect.Type, seen []reflect.Type) *TypeDesc {
+	d := &TypeDesc{Kind: t.Kind().String()}
+	// 匿名类型的名字就是它的结构, 不重复
+	// 只有具名类型可以引用自己, 切片、map 等也可能是递归的, 如 type Tree []Tree
+	if t.Name() != "" {
+		d.Name = t.String()
+		for _, s := range seen {
+			if s == t {
+				d.Recursive = true
+				return d
+			}
+		}
+		seen = append(seen, t)
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		d.Elem = describeType(t.Elem(), seen)
+	case reflect.Array:
+		d.Len = t.Len()
+		d.Elem = describeType(t.Elem(), seen)
+	case reflect.Map:
+		d.Key = describeType(t.Key(), seen)
+		d.Elem = describeType(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			jsonName, skip := jsonFieldName(f)
+			if skip {
+				continue
+			}
+			d.Fields = append(d.Fields, FieldDesc{
+				Name:     f.Name,
+				JSONName: jsonName,
+				Type:     describeType(f.Type, seen),
+				Embedded: f.Anonymous,
+			})
+		}
+	}
+	return d
+}
+
+// 与 encoding/json 的规则一致: tag 为 "-" 的字段不编码, tag 中的名字为空时使用字段名
+func jsonFieldName(f reflect.StructField) (name string, skip bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if name, _, _ = strings.Cut(tag, ","); name == "" {
+		name = f.Name
+	}
+	return name, false
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"GeeRPC/foo"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Node struct {
+	Value    int               `json:"value"`
+	Children []*Node           `json:"children,omitempty"`
+	Labels   map[string]string `json:"-"`
+}
+
+type Tree int
+
+// 递归的切片和 map
+type (
+	Forest []Forest
+	Dict   map[string]Dict
+)
+
+func (Tree) Size(root *Node, reply *int) error {
+	*reply = 1
+	for _, c := range root.Children {
+		var n int
+		_ = Tree(0).Size(c, &n)
+		*reply += n
+	}
+	return nil
+}
+
+func TestServer_Reflection(t *testing.T) {
+	t.Parallel()
+	addr := startTestServer(t, "mem", NewServer(), new(foo.Foo), new(Tree))
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("mem", addr, &Option{CodecType: ct})
+		_assert(err == nil, "dial failed: %v", err)
+
+		var list ListReply
+		err = client.Call(ReflectionList, ListArgs{}, &list)
+		_assert(err == nil, "%s: list failed: %v", ct, err)
+		_assert(len(list.Services) == 2 && list.Services[0].Name == "Foo" && list.Services[1].Name == "Tree",
+			"%s: unexpected services %+v", ct, list.Services)
+		_assert(len(list.Services[0].Methods) == 1 && list.Services[0].Methods[0] == "Sum", "%s: unexpected methods %+v", ct, list.Services[0])
+		err = client.Call(ReflectionList, ListArgs{Service: "Bar"}, &list)
+		_assert(CodeOf(err) == NotFound, "%s: expect NotFound, got %v", ct, err)
+
+		var desc ServiceDesc
+		err = client.Call(ReflectionDescribe, DescribeArgs{Name: "Foo.Sum"}, &desc)
+		_assert(err == nil && len(desc.Methods) == 1, "%s: describe failed: %v", ct, err)
+		arg := desc.Methods[0].Arg
+		_assert(arg.Name == "foo.Args" && arg.Kind == "struct" && len(arg.Fields) == 2, "%s: unexpected arg %+v", ct, arg)
+		_assert(arg.Fields[0].Name == "Num1" && arg.Fields[0].JSONName == "Num1" && arg.Fields[0].Type.Kind == "int", "%s: unexpected field %+v", ct, arg.Fields[0])
+		_assert(desc.Methods[0].Reply.Kind == "int", "%s: unexpected reply %+v", ct, desc.Methods[0].Reply)
+
+		desc = ServiceDesc{}
+		err = client.Call(ReflectionDescribe, DescribeArgs{Name: "Tree"}, &desc)
+		_assert(err == nil && desc.Name == "Tree" && len(desc.Methods) == 1, "%s: describe failed: %v", ct, err)
+		node := desc.Methods[0].Arg
+		_assert(node.Name == "service.Node" && len(node.Fields) == 2, "%s: json:\"-\" field should be skipped: %+v", ct, node)
+		children := node.Fields[1]
+		_assert(children.JSONName == "children" && children.Type.Kind == "slice" && children.Type.Elem.Kind == "ptr", "%s: unexpected field %+v", ct, children)
+		_assert(children.Type.Elem.Elem.Recursive && children.Type.Elem.Elem.Fields == nil, "%s: recursive type should not be expanded", ct)
+
+		err = client.Call(ReflectionDescribe, DescribeArgs{Name: "Foo.Missing"}, &desc)
+		_assert(CodeOf(err) == NotFound, "%s: expect NotFound, got %v", ct, err)
+		_ = client.Close()
+	}
+}
+
+func TestDescribeType_RecursiveNonStruct(t *testing.T) {
+	t.Parallel()
+	forest := describeType(reflect.TypeOf(Forest(nil)), nil)
+	_assert(forest.Kind == "slice" && forest.Name == "service.Forest" && !forest.Recursive, "unexpected desc %+v", forest)
+	_assert(forest.Elem.Recursive && forest.Elem.Elem == nil, "recursive slice should not be expanded: %+v", forest.Elem)
+
+	dict := describeType(reflect.TypeOf(Dict(nil)), nil)
+	_assert(dict.Kind == "map" && dict.Key.Kind == "string" && !dict.Recursive, "unexpected desc %+v", dict)
+	_assert(dict.Elem.Recursive && dict.Elem.Key == nil && dict.Elem.Elem == nil, "recursive map should not be expanded: %+v", dict.Elem)
+
+	// 指向自己的指针经过结构体以外的具名类型
+	type Ptr *Ptr
+	ptr := describeType(reflect.TypeOf(Ptr(nil)), nil)
+	_assert(ptr.Elem.Recursive, "recursive pointer should not be expanded: %+v", ptr)
+}
+
+// 反射服务不依赖 Register, 没有注册任何服务的 &Server{} 也能使用
+func TestServer_ReflectionWithoutRegister(t *testing.T) {
+	t.Parallel()
+	client, err := Dial("mem", startTestServer(t, "mem", &Server{}))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var list ListReply
+	err = client.Call(ReflectionList, ListArgs{}, &list)
+	_assert(err == nil && len(list.Services) == 0, "expect an empty list, got %+v, %v", list.Services, err)
+	_assert(client.IsIdempotent(ReflectionList), "expect reflection methods to be idempotent")
+}
+
+// 只接受 protobuf 的服务端不提供反射服务, 调用返回 Unimplemented
+func TestServer_ReflectionProtobufOnly(t *testing.T) {
+	t.Parallel()
+	server := &Server{CodecTypes: []codec.Type{codec.ProtobufType}}
+	client, err := Dial("mem", startTestServer(t, "mem", server, new(ProtoOnly)), &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(!client.IsIdempotent(ReflectionList), "reflection methods should not be advertised")
+	err = client.Call(ReflectionList, &wrapperspb.StringValue{}, &wrapperspb.StringValue{})
+	_assert(CodeOf(err) == Unimplemented && strings.Contains(err.Error(), "protobuf"), "expect Unimplemented, got %v", err)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// REST 网关: 每个已注册的方法都暴露为 POST /rpc/{Service}/{Method}
+// 请求 body 是 JSON 编码的参数, 为空时使用参数的零值; 成功时回复 JSON 编码的结果,
+// 失败时回复 {"code": "NotFound", "message": "..."}, HTTP 状态码由错误码决定, 见 Code.HTTPStatus
+
+// REST 网关的默认路径前缀
+const DefaultRESTPath = "/rpc/"
+
+// RESTError 是 REST 网关回复的错误
+type RESTError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// RESTHandler 返回 REST 网关, 需要挂载在 DefaultRESTPath 下:
+//
+//	http.Handle(service.DefaultRESTPath, server.RESTHandler())
+func (server *Server) RESTHandler() http.Handler {
+	return http.HandlerFunc(server.serveREST)
+}
+
+func (server *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, &RESTError{Code: Unimplemented.String(), Message: "rpc gateway: must POST"})
+		return
+	}
+	start := time.Now()
+	ctx := withPeer(withHTTPTraceparent(r.Context(), r), &Peer{Addr: httpAddr(r.RemoteAddr)})
+	serviceMethod, inv, reqSize, err := server.readREST(w, r)
+	entry := server.newGatewayAccessEntry(ctx, "rest", start, reqSize)
+	if err == nil {
+		// 与原生调用走同一个调用路径, 拦截器、超时和错误码都相同
+		err = server.invoke(withAccessEntry(ctx, entry), serviceMethod, inv)
+	}
+	var respSize int
+	if err != nil {
+		respSize = writeRESTError(w, err)
+	} else {
+		respSize = writeJSON(w, http.StatusOK, inv.replyv.Interface())
+	}
+	server.logAccess(entry, &codec.Header{ServiceMethod: serviceMethod}, response{respSize, err})
+}
+
+// 从路径中找到方法并解码参数, 返回 "Service.Method" 和请求 body 的字节数
+// 路径不合法时 serviceMethod 为原始路径
+func (server *Server) readREST(w http.ResponseWriter, r *http.Request) (serviceMethod string, inv *invocation, size int, err error) {
+	path := strings.TrimPrefix(r.URL.Path, DefaultRESTPath)
+	parts := strings.Split(path, "/")
+	if path == r.URL.Path || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return r.URL.Path, nil, 0, Errorf(NotFound, "rpc gateway: path must be %s{Service}/{Method}", DefaultRESTPath)
+	}
+	serviceMethod = parts[0] + "." + parts[1]
+
+	svc, mtype, err := server.findServiceDotMethod(serviceMethod)
+	if err != nil {
+		return serviceMethod, nil, 0, err
+	}
+	inv = &invocation{svc: svc, mtype: mtype, argv: mtype.newArgv(), replyv: mtype.newReplyv()}
+	size, err = server.decodeRESTBody(w, r, inv)
+	return serviceMethod, inv, size, err
+}
+
+// 返回 body 的字节数
+func (server *Server) decodeRESTBody(w http.ResponseWriter, r *http.Request, inv *invocation) (int, error) {
+	maxBody := int64(server.MaxBodySize)
+	if maxBody <= 0 {
+		maxBody = codec.DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return len(body), Errorf(ResourceExhausted, "rpc gateway: %v", err)
+		}
+		return len(body), Errorf(InvalidArgument, "rpc gateway: read body: %v", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return len(body), nil
+	}
+	if err := json.Unmarshal(body, argvPointer(inv.argv)); err != nil {
+		return len(body), Errorf(InvalidArgument, "rpc gateway: decode args: %v", err)
+	}
+	return len(body), nil
+}
+
+// 返回写出的 body 字节数
+func writeRESTError(w http.ResponseWriter, err error) int {
+	code := CodeOf(err)
+	if d := RetryAfterOf(err); d > 0 {
+		// HTTP 的 Retry-After 以秒为单位, 向上取整
+		w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
+	return writeJSON(w, code.HTTPStatus(), &RESTError{Code: code.String(), Message: err.Error()})
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"GeeRPC/foo"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 拒绝 Num1 为负数的调用, 用来检查原生调用和网关是否共用拦截器
+func denyNegative(ctx context.Context, info *CallInfo, next Handler) error {
+	if args, ok := info.Args.(foo.Args); ok && args.Num1 < 0 {
+		return Errorf(PermissionDenied, "negative Num1")
+	}
+	return next(ctx, info)
+}
+
+func TestServer_REST(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Use(denyNegative)
+	var f foo.Foo
+	addr := startTestServer(t, "tcp", server, &f)
+	mux := http.NewServeMux()
+	mux.Handle(DefaultRESTPath, server.RESTHandler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(path, body string) (int, string) {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		_assert(err == nil, "post failed: %v", err)
+		defer func() { _ = resp.Body.Close() }()
+		var v json.RawMessage
+		_ = json.NewDecoder(resp.Body).Decode(&v)
+		return resp.StatusCode, string(v)
+	}
+
+	status, body := post("/rpc/Foo/Sum", `{"Num1": 1, "Num2": 2}`)
+	_assert(status == http.StatusOK && body == "3", "got %d %s", status, body)
+	status, body = post("/rpc/Foo/Sum", ``)
+	_assert(status == http.StatusOK && body == "0", "empty body should use zero args, got %d %s", status, body)
+	status, body = post("/rpc/Foo/Nope", `{}`)
+	_assert(status == http.StatusNotFound && strings.Contains(body, `"code":"Unimplemented"`), "got %d %s", status, body)
+	status, body = post("/rpc/Foo/Sum", `{"Num1": "x"}`)
+	_assert(status == http.StatusBadRequest && strings.Contains(body, `"code":"InvalidArgument"`), "got %d %s", status, body)
+	status, body = post("/rpc/Foo/Sum", `{"Num1": -1}`)
+	_assert(status == http.StatusForbidden && strings.Contains(body, `"code":"PermissionDenied"`), "got %d %s", status, body)
+
+	// 原生调用得到相同的错误码
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &foo.Args{Num1: -1}, &reply)
+	_assert(CodeOf(err) == PermissionDenied && err.Error() == "negative Num1", "got %v (%s)", err, CodeOf(err))
+	err = client.Call("Foo.Nope", &foo.Args{}, &reply)
+	_assert(CodeOf(err) == Unimplemented, "got %v (%s)", err, CodeOf(err))
+}
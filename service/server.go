@@ -2,29 +2,45 @@ package service
 
 import (
 	"GeeRPC/codec"
-	"encoding/json"
+	"GeeRPC/trace"
+	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// GeeRPC 客户端固定采用 JSON 编码 Option，后续的 header 和 body 的编码方式由 Option 中的 CodeType 指定
-// | Option{MagicNumber: xxx, CodecType: xxx} | Header{ServiceMethod ...} | Body interface{} |
-// | <------      固定 JSON 编码      ------>  | <-------   编码方式由 CodeType 决定   ------->|
+// GeeRPC 握手阶段固定采用 JSON 编码 Option 和 OptionReply，后续的 header 和 body 的编码方式由握手协商决定
+// | Option{MagicNumber: xxx, CodecTypes: xxx} | OptionReply{CodecType: xxx} | Header{ServiceMethod ...} | Body interface{} |
+// | <------                固定 JSON 编码                 ------>           | <-----   编码方式由协商结果决定   ----->  |
+// 每一部分都以 4 字节长度作为前缀单独成帧, 见 codec.WriteFrame, 握手过程见 handshake.go
 const Identify = 0x31dfa9
 
 // 协议协商信息
 type Option struct {
-	OptionIdentify int //标识这是个geerpc包
-	CodecType      codec.Type
+	OptionIdentify int           //标识这是个geerpc包
+	CodecType      codec.Type    // 首选的编码方式, CodecTypes 为空时使用
+	CodecTypes     []codec.Type  // 客户端支持的编码方式, 按偏好排序, 由服务端选出双方都支持的一个
 	ConnectTimeout time.Duration // 客户端连接服务器时限, 0为无限制
 	HandleTimeout  time.Duration // 服务器处理和发送响应的时限, 0为无限制
+	MaxHeaderSize  int           `json:"-"` // 客户端接收 header 的大小上限, 0 表示 codec.DefaultMaxHeaderSize
+	MaxBodySize    int           `json:"-"` // 客户端接收和发送 body 的大小上限, 0 表示 codec.DefaultMaxBodySize
+	// body 压缩算法 (如 codec.Gzip, codec.Snappy), 空表示不压缩, 双方都使用同一个算法
+	Compressor        string
+	CompressThreshold int // 达到该大小的 body 才压缩, 0 表示 codec.DefaultCompressThreshold
+
+	HeartbeatInterval time.Duration `json:"-"` // 客户端发送心跳的间隔, 0 表示不发送也不检测, 见 heartbeat.go
+	// 为客户端的每次调用创建 client span 并传给服务端, 为空表示不记录, CallContext 的 ctx 中的追踪上下文仍会传给服务端
+	Tracer *trace.Tracer `json:"-"`
+	// 不为空时客户端通过 TLS 连接 tcp 和 unix 地址, 见 dialConn
+	TLSConfig *tls.Config `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -35,7 +51,57 @@ var DefaultOption = &Option{
 }
 
 type Server struct {
-	serviceMap sync.Map
+	serviceMap     sync.Map
+	reflectionOnce sync.Once // 第一次查找 _Reflection 时创建, 不放在 serviceMap 中, 见 reflection.go
+	reflection     *service
+	interceptors   []Interceptor
+
+	// 以下配置需要在 Accept 之前设置
+	MaxHeaderSize int          // 请求 header (包括 Option) 的大小上限, 0 表示 codec.DefaultMaxHeaderSize
+	MaxBodySize   int          // 请求和回复 body 的大小上限, 0 表示 codec.DefaultMaxBodySize
+	CodecTypes    []codec.Type // 服务端接受的编码方式, 为空表示所有已注册的编码方式, 需要在 Register 之前设置
+	// 已注册的方法中有参数或返回值不是 proto.Message 的, 此后不能再把 CodecTypes 限制为只有 protobuf
+	nonProto int32
+
+	HeartbeatInterval time.Duration // 发送心跳的间隔, 0 表示不发送也不检测, 见 heartbeat.go
+	IdleTimeout       time.Duration // 连接上没有调用的时间超过该值时关闭连接, 0 表示不限
+
+	HandleTimeout time.Duration // 处理请求的时限, 0 表示使用 DefaultOption.HandleTimeout, 负数表示不限
+	// 每个方法已经超时但还在执行的调用数上限, 达到上限后拒绝该方法的新调用, 0 表示不限, 见 callWithTimeout
+	MaxAbandoned int
+
+	// 准入控制, 见 admission.go
+	// 请求的优先级只在排队时起作用: 没有设置 MaxInFlight 或 MaxConnInFlight 时请求不会排队, 全部立即处理, 优先级被忽略
+	MaxInFlight     int        // 所有连接同时处理的请求数上限, 0 表示不限
+	MaxConnInFlight int        // 每个连接同时处理的请求数上限, 0 表示不限
+	MaxQueue        int        // 超过上限的请求排队等待, 这是队列长度的上限, 0 表示不排队直接拒绝
+	ShedPolicy      ShedPolicy // 队列满时丢弃哪个请求
+
+	schedOnce sync.Once
+	sched     *scheduler
+
+	metricsOnce sync.Once
+	stats       *serverMetrics
+
+	// 为每次调用创建 span, 父 span 来自请求 metadata 或 HTTP header 中的 traceparent, 为空表示不记录
+	Tracer *trace.Tracer
+	// 服务端自身的日志, 为空时使用 slog.Default()
+	Logger *slog.Logger
+	// 访问日志, 为空表示不记录, 见 accesslog.go
+	AccessLog *AccessLog
+
+	// 优雅关闭, 见 shutdown.go
+	mu         sync.Mutex
+	inShutdown int32
+	listeners  map[net.Listener]struct{}
+	conns      map[codec.Codec]*connState
+}
+
+func (server *Server) logger() *slog.Logger {
+	if server.Logger != nil {
+		return server.Logger
+	}
+	return slog.Default()
 }
 
 func NewServer() *Server {
@@ -46,14 +112,75 @@ func NewServer() *Server {
 var DefaultServer = NewServer()
 
 // 注册方法
+// 服务端只接受 protobuf 编码时, 参数和返回值必须实现 proto.Message, 否则返回错误, 整个服务都不注册
 func (server *Server) Register(receiver interface{}) error {
-	s := newService(receiver)
+	var checks []methodCheck
+	if server.protobufOnly() {
+		checks = append(checks, checkProtoMessage)
+	}
+	s, err := newService(receiver, checks...)
+	if err != nil {
+		return err
+	}
+	if len(s.skipped) > 0 {
+		var errs []string
+		for name, err := range s.skipped {
+			errs = append(errs, s.name+"."+name+": "+err.Error())
+		}
+		sort.Strings(errs)
+		return errors.New("rpc server: server only accepts protobuf, " + strings.Join(errs, "; "))
+	}
+	for _, m := range s.method {
+		if checkProtoMessage(m.ArgType, m.ReplyType) != nil {
+			atomic.StoreInt32(&server.nonProto, 1)
+			break
+		}
+	}
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc server: service already defined: " + s.name)
 	}
+	for _, name := range sortedKeys(s.method) {
+		server.logger().Info("rpc server: register method", "method", s.name+"."+name)
+	}
 	return nil
 }
 
+func sortedKeys(methods map[string]*methodType) []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 所有幂等方法的 "Service.Method", 握手时发给客户端
+func (server *Server) idempotentMethods() []string {
+	var methods []string
+	server.serviceMap.Range(func(_, v interface{}) bool {
+		svc := v.(*service)
+		for name, m := range svc.method {
+			if m.idempotent {
+				methods = append(methods, svc.name+"."+name)
+			}
+		}
+		return true
+	})
+	if svc, err := server.reflectionService(); err == nil {
+		for name, m := range svc.method {
+			if m.idempotent {
+				methods = append(methods, svc.name+"."+name)
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func (server *Server) protobufOnly() bool {
+	return len(server.CodecTypes) == 1 && server.CodecTypes[0] == codec.ProtobufType
+}
+
 // 默认Server的注册
 func Register(receiver interface{}) error { return DefaultServer.Register(receiver) }
 
@@ -61,22 +188,28 @@ func Register(receiver interface{}) error { return DefaultServer.Register(receiv
 func (server *Server) findServiceDotMethod(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = Errorf(InvalidArgument, "rpc server: service/method request ill-formed: %s", serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 
 	// 寻找service
-	svci, ok := server.serviceMap.Load(serviceName)
-	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
-		return
+	if serviceName == ReflectionService {
+		if svc, err = server.reflectionService(); err != nil {
+			return
+		}
+	} else {
+		svci, ok := server.serviceMap.Load(serviceName)
+		if !ok {
+			err = Errorf(Unimplemented, "rpc server: can't find service %s", serviceName)
+			return
+		}
+		svc = svci.(*service)
 	}
-	svc = svci.(*service)
 	// 寻找service的methodName方法
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = Errorf(Unimplemented, "rpc server: can't find method %s", methodName)
 		return svc, mtype, err
 	}
 	return svc, mtype, nil
@@ -84,11 +217,16 @@ func (server *Server) findServiceDotMethod(serviceMethod string) (svc *service,
 
 // 处理新的连接
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
 	// 循环监听
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept err: ", err)
+			server.logger().Info("rpc server: stop accepting", "addr", lis.Addr(), "err", err)
 			return
 		}
 		// 利用协程处理
@@ -103,68 +241,132 @@ func Accept(lis net.Listener) {
 // 协程连接处理
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
-
-	var opt Option
-	// json解码出opt
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: option error: ", err)
-		return
+	m := server.metrics()
+	m.connsTotal.Inc()
+	m.conns.Inc()
+	defer m.conns.Dec()
+	// 对端信息需要原始的连接
+	p, err := peerOf(conn)
+	if err != nil {
+		// 拿不到身份时不影响调用, 依赖 Cred 的方法自行拒绝
+		server.logger().Warn("rpc server: get peer credentials failed", "err", err)
 	}
+	ctx := withPeer(context.Background(), p)
 
-	if opt.OptionIdentify != Identify {
-		log.Printf("rpc server: invalid identifer %x", opt.OptionIdentify)
-		return
-	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	cc, err := server.handshake(m.countConn(conn))
+	if err != nil {
+		server.logger().Warn("rpc server: handshake failed", "peer", peerAddr(p), "err", err)
+		m.handshakeFailures.Inc()
 		return
 	}
-
-	// 根据opt进行head和body解码
-	// f(conn)返回一个具体类型的解编码接口
-	server.serverCodecAndHandle(f(conn))
+	// 同一连接上的调用共享对端信息
+	server.serverCodecAndHandle(ctx, cc)
 }
 
 // 存储调请求的信息
 type request struct {
-	h            *codec.Header
-	argv, replyv reflect.Value // 由于编码方式要到运行时确定, 所以用reflect.Value类型
-	mtype        *methodType
-	svc          *service
+	h *codec.Header
+	invocation
+}
+
+// 用于指标的方法名, 方法不存在时为 unknownMethod
+func (req *request) methodName() string {
+	if req.mtype == nil {
+		return unknownMethod
+	}
+	return req.h.ServiceMethod
 }
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
 // 读取, 处理, 回复请求
-func (server *Server) serverCodecAndHandle(cc codec.Codec) {
-	sending := new(sync.Mutex) // 保证回复报文不会交织
-	wg := new(sync.WaitGroup)  // 类似于信号量, 确保goroutine在关闭连接前已经全部handleRequest结束
+func (server *Server) serverCodecAndHandle(ctx context.Context, cc codec.Codec) {
+	wg := new(sync.WaitGroup) // 类似于信号量, 确保goroutine在关闭连接前已经全部handleRequest结束
+	state := newConnState()
+	if !server.trackConn(cc, state, true) {
+		_ = cc.Close()
+		return
+	}
+	defer server.trackConn(cc, state, false)
+	stop := make(chan struct{})
+	defer close(stop)
+	if server.HeartbeatInterval > 0 {
+		go state.run(cc, server.HeartbeatInterval, server.logger(), stop, func() {
+			server.logger().Warn("rpc server: missed heartbeats, close connection", "peer", peerAddrOf(ctx))
+			_ = cc.Close()
+		})
+	}
+	if server.IdleTimeout > 0 {
+		go server.watchIdle(cc, state, stop)
+	}
+	calls := newInflight()
+	sched, slots := server.scheduler(), new(connSlots)
 	for {
-		req, err := server.readRequest(cc)
+		h, err := server.readRequestHeader(ctx, cc)
 		if err != nil {
-			// 解析失败, 结束循环
-			if req == nil {
+			break
+		}
+		start := time.Now()
+		state.touch()
+		if ok, err := handleHeartbeat(cc, h); ok {
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if isCancel(h) {
+			if cc.ReadBody(nil) != nil {
 				break
 			}
-			req.h.Err = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			calls.cancel(h.Seq)
+			continue
+		}
+		state.begin()
+		req, err := server.readRequest(ctx, cc, h)
+		if err == nil && server.shuttingDown() {
+			err = errServerShutdown
+		}
+		entry := server.newAccessEntry(ctx, cc, start)
+		if err != nil {
+			server.metrics().rejected(req.methodName(), err)
+			server.logAccess(entry, req.h, server.sendError(cc, req.h, err))
+			state.end()
 			continue
 		}
 		wg.Add(1)
-		// 新起routine处理请求
-		go server.handleRequest(cc, req, sending, wg, DefaultOption.HandleTimeout)
+		reqCtx, done := calls.add(withAccessEntry(withMetadata(ctx, h.Metadata), entry), h.Seq)
+		finish := func() {
+			done()
+			state.end()
+			wg.Done()
+		}
+		// 由调度器决定何时新起routine处理请求
+		sched.submit(&task{
+			conn:     slots,
+			priority: priorityOf(h.Priority),
+			run: func() {
+				defer finish()
+				server.handleRequest(reqCtx, cc, req, entry)
+			},
+			reject: func(err error) {
+				defer finish()
+				server.metrics().rejected(req.methodName(), err)
+				server.logAccess(entry, req.h, server.sendError(cc, req.h, err))
+			},
+		})
 	}
+	sched.dropConn(slots)
 	wg.Wait()
 	_ = cc.Close()
 }
 
-func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
+func (server *Server) readRequestHeader(ctx context.Context, cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+		// 关闭期间连接被 Shutdown 关闭, 不是错误
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !server.shuttingDown() {
+			server.logger().Warn("rpc server: read header failed", "peer", peerAddrOf(ctx), "err", err)
 		}
 		// 读完了
 		return nil, err
@@ -172,83 +374,94 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	return &h, nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (req *request, err error) {
-	h, err := server.readRequestHeader(cc)
-	if err != nil {
-		return nil, err
-	}
+func (server *Server) readRequest(ctx context.Context, cc codec.Codec, h *codec.Header) (req *request, err error) {
 	req = &request{h: h}
 	// 根据header找到对应服务
 	req.svc, req.mtype, err = server.findServiceDotMethod(h.ServiceMethod)
 	if err != nil {
+		// 丢弃 body, 保证下一个请求从 header 帧开始读
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 	req.argv = req.mtype.newArgv()
 	req.replyv = req.mtype.newReplyv()
 
 	// 读取输入参数
-	// make sure that argvi is a pointer, ReadBody need a pointer as parameter
-	argvi := req.argv.Interface()
-	if req.argv.Type().Kind() != reflect.Ptr {
-		argvi = req.argv.Addr().Interface()
+	if err = cc.ReadBody(argvPointer(req.argv)); err != nil {
+		server.logger().Warn("rpc server: read argv failed", "method", h.ServiceMethod, "peer", peerAddrOf(ctx), "err", err)
+		if errors.Is(err, codec.ErrBodyTooLarge) {
+			return req, &Error{Code: ResourceExhausted, Message: err.Error()}
+		}
+		return req, &Error{Code: InvalidArgument, Message: err.Error()}
 	}
 
-	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read argv error:", err)
-		return req, err
+	return req, nil
+}
+
+// make sure that argvi is a pointer, ReadBody need a pointer as parameter
+func argvPointer(argv reflect.Value) interface{} {
+	if argv.Type().Kind() != reflect.Ptr {
+		return argv.Addr().Interface()
 	}
+	return argv.Interface()
+}
 
-	return req, nil
+// 回复的结果, 用于访问日志
+type response struct {
+	size int   // 回复在线上的字节数
+	err  error // 客户端收到的错误, 或者写回复失败的错误
 }
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error: ", err)
+// Codec 的 Write 可以并发调用, 由 codec 保证回复报文不会交织
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}) response {
+	n, err := writeSized(cc, h, body)
+	if err == nil {
+		return response{n, nil}
+	}
+	// 回复编码失败 (超长、JSON 中的 NaN、codec 不支持的类型等) 时什么都没有写出,
+	// 改为回复一个错误, 避免客户端一直等待
+	failed := &Error{Code: Internal, Message: "rpc server: encode reply: " + err.Error()}
+	if errors.Is(err, codec.ErrBodyTooLarge) {
+		failed = &Error{Code: ResourceExhausted, Message: "rpc server: reply " + err.Error()}
+	}
+	server.logger().Warn("rpc server: write response failed", "method", h.ServiceMethod, "err", err)
+	h.Err = failed.Message
+	h.Code = uint32(failed.Code)
+	h.Metadata = nil
+	if n, err = writeSized(cc, h, invalidRequest); err == nil {
+		return response{n, failed}
 	}
+	// 连接已经不可用, 或者连错误都写不出去, 关闭连接让客户端的调用失败
+	server.logger().Warn("rpc server: write error response failed, close connection", "method", h.ServiceMethod, "err", err)
+	_ = cc.Close()
+	return response{n, err}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
-	// 加一个buf, 防止超时后子协程阻塞在写通道
-	called := make(chan struct{}, 1)
-	sent := make(chan struct{}, 1)
-	done := make(chan struct{}) // 发送完成, 确保只发一次response
-	defer close(done)
-	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		select {
-		case called <- struct{}{}:
-			if err != nil {
-				req.h.Err = err.Error()
-				server.sendResponse(cc, req.h, invalidRequest, sending)
-				sent <- struct{}{}
-				return
-			}
-			server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-			sent <- struct{}{}
-		case <-done:
-			close(called)
-			close(sent)
-			return
-		}
+func writeSized(cc codec.Codec, h *codec.Header, body interface{}) (int, error) {
+	if s, ok := cc.(codec.Sizer); ok {
+		return s.WriteSized(h, body)
+	}
+	return 0, cc.Write(h, body)
+}
 
-	}()
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
+// 带上错误码回复一个错误
+func (server *Server) sendError(cc codec.Codec, h *codec.Header, err error) response {
+	h.Err = err.Error()
+	h.Code = uint32(CodeOf(err))
+	h.Metadata = errorMetadata(err)
+	resp := server.sendResponse(cc, h, invalidRequest)
+	if resp.err == nil {
+		resp.err = err
 	}
-	select {
-	// 调用超时, 直接发送错误信息
-	case <-time.After(timeout):
-		req.h.Err = fmt.Sprintf("rpc server: request handle timeout: expect within: %s", timeout)
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		done <- struct{}{}
+	return resp
+}
 
-	// 调用未超时, 阻塞等待发送完成
-	case <-called:
-		<-sent
+func (server *Server) handleRequest(ctx context.Context, cc codec.Codec, req *request, entry *accessEntry) {
+	if err := server.invoke(ctx, req.h.ServiceMethod, &req.invocation); err != nil {
+		server.logAccess(entry, req.h, server.sendError(cc, req.h, err))
+		return
 	}
+	// 请求的 metadata 不需要发回客户端
+	req.h.Metadata = nil
+	server.logAccess(entry, req.h, server.sendResponse(cc, req.h, req.replyv.Interface()))
 }
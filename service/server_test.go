@@ -0,0 +1,367 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"GeeRPC/memconn"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Echo int
+
+func (e Echo) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+// 启动测试用的服务器并注册 rcvrs, 返回可以直接用于 Dial(network, addr) 的地址
+// network 为 "mem" 时监听内存连接, "tcp" 时监听本机的随机端口
+func startTestServer(tb testing.TB, network string, server *Server, rcvrs ...interface{}) string {
+	tb.Helper()
+	var l net.Listener
+	var err error
+	if network == "mem" {
+		l, err = memconn.Listen("", nil)
+	} else {
+		l, err = net.Listen(network, "127.0.0.1:0")
+	}
+	if err != nil {
+		tb.Fatalf("listen %s failed: %v", network, err)
+	}
+	for _, rcvr := range rcvrs {
+		if err := server.Register(rcvr); err != nil {
+			tb.Fatalf("register failed: %v", err)
+		}
+	}
+	go server.Accept(l)
+	tb.Cleanup(func() { _ = l.Close() })
+	return l.Addr().String()
+}
+
+// 轮询直到 cond 成立, 2 秒内不成立时测试失败
+func waitFor(tb testing.TB, cond func() bool, format string, args ...interface{}) {
+	tb.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			tb.Fatalf(format, args...)
+		}
+	}
+}
+
+func TestServer_MaxBodySize(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxBodySize = 1024
+	var e Echo
+	addr := startTestServer(t, "tcp", server, &e)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Echo.Echo", strings.Repeat("x", 1500), &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "exceeds size limit"), "expect a size limit error, got %v", err)
+
+	// 超长不多的请求被整帧丢弃, 连接仍然可用
+	err = client.Call("Echo.Echo", "hello", &reply)
+	_assert(err == nil && reply == "hello", "connection should survive an oversized body: %v", err)
+
+	// 超出太多时断开连接
+	err = client.Call("Echo.Echo", strings.Repeat("x", 4096), &reply)
+	_assert(err != nil, "expect the call to fail")
+	_assert(!client.IsAvalable() || client.Call("Echo.Echo", "hello", &reply) != nil, "expect connection to be closed")
+}
+
+// 回复无法编码: JSON 不支持 NaN
+type NaN int
+
+func (NaN) Get(args int, reply *float64) error {
+	*reply = math.NaN()
+	return nil
+}
+
+func TestServer_EncodeReplyFailed(t *testing.T) {
+	t.Parallel()
+	client, err := Dial("mem", startTestServer(t, "mem", NewServer(), new(NaN), new(Echo)), &Option{CodecType: codec.JsonType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var f float64
+	err = client.CallContext(ctx, "NaN.Get", 1, &f)
+	_assert(CodeOf(err) == Internal && strings.Contains(err.Error(), "encode reply"), "expect Internal, got %v", err)
+	// 连接仍然可用
+	var reply string
+	_assert(client.Call("Echo.Echo", "hi", &reply) == nil && reply == "hi", "expect connection to stay usable")
+}
+
+func TestServer_BadFrame(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxHeaderSize = 1024
+	addr := startTestServer(t, "tcp", server)
+
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = conn.Close() }()
+
+	p, _ := json.Marshal(DefaultOption)
+	_assert(codec.WriteFrame(conn, p) == nil, "write option failed")
+	_, err = codec.ReadFrame(conn, 0)
+	_assert(err == nil, "read option reply failed: %v", err)
+	// 声明一个远超上限的 header 帧, 服务端应直接断开连接
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], 1<<30)
+	_, _ = conn.Write(l[:])
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	_, err = conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect the server to close the connection, got %v", err)
+}
+
+func TestServer_NegotiateCodec(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.CodecTypes = []codec.Type{codec.JsonType}
+	var e Echo
+	addr := startTestServer(t, "tcp", server, &e)
+
+	client, err := Dial("tcp", addr, &Option{CodecTypes: []codec.Type{"application/unknown", codec.GobType, codec.JsonType}})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.opt.CodecType == codec.JsonType, "expect json codec, got %s", client.opt.CodecType)
+	var reply string
+	err = client.Call("Echo.Echo", "hello", &reply)
+	_assert(err == nil && reply == "hello", "call over json codec failed: %v", err)
+
+	_, err = Dial("tcp", addr, &Option{CodecType: codec.GobType})
+	_assert(err != nil && strings.Contains(err.Error(), "no mutual codec"), "expect a negotiation error, got %v", err)
+}
+
+type ProtoEcho int
+
+func (e ProtoEcho) Echo(args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = args.Value
+	return nil
+}
+
+// 参数不是 proto.Message, protobuf-only 的服务端拒绝注册整个服务
+func (e ProtoEcho) Plain(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+// 只有 protobuf 方法的服务
+type ProtoOnly int
+
+func (e ProtoOnly) Echo(args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = args.Value
+	return nil
+}
+
+func TestServer_ProtobufOnly(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.CodecTypes = []codec.Type{codec.ProtobufType}
+	err := server.Register(new(ProtoEcho))
+	_assert(err != nil && strings.Contains(err.Error(), "ProtoEcho.Plain"), "expect register to fail on Plain, got %v", err)
+	_, ok := server.serviceMap.Load("ProtoEcho")
+	_assert(!ok, "ProtoEcho should not be registered")
+
+	addr := startTestServer(t, "tcp", server, new(ProtoOnly))
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	reply := &wrapperspb.StringValue{}
+	err = client.Call("ProtoOnly.Echo", wrapperspb.String("hello"), reply)
+	_assert(err == nil && reply.Value == "hello", "call over protobuf codec failed: %v", err)
+}
+
+// Register 之后才限制为 protobuf 时拒绝握手
+func TestServer_ProtobufOnlyAfterRegister(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	addr := startTestServer(t, "tcp", server, new(Echo))
+	server.CodecTypes = []codec.Type{codec.ProtobufType}
+	_, err := Dial("tcp", addr, &Option{CodecType: codec.ProtobufType})
+	_assert(err != nil && strings.Contains(err.Error(), "proto.Message"), "expect handshake to fail, got %v", err)
+}
+
+// Wait 不理会 ctx, 一直阻塞到 release 关闭; Context 在 ctx 结束时返回
+type Stuck struct {
+	release  chan struct{}
+	canceled chan error
+}
+
+func (s *Stuck) Wait(args int, reply *int) error {
+	<-s.release
+	*reply = args
+	return nil
+}
+
+func (s *Stuck) Context(ctx context.Context, args int, reply *int) error {
+	<-ctx.Done()
+	s.canceled <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestServer_AbandonedHandlers(t *testing.T) {
+	t.Parallel()
+	server := &Server{HandleTimeout: 20 * time.Millisecond, MaxAbandoned: 1}
+	stuck := &Stuck{release: make(chan struct{}), canceled: make(chan error, 1)}
+	client, err := Dial("mem", startTestServer(t, "mem", server, stuck))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Stuck.Wait", 1, &reply)
+	_assert(CodeOf(err) == DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	_assert(server.NumAbandoned() == 1, "expect 1 abandoned handler, got %d", server.NumAbandoned())
+	// 达到上限后拒绝该方法的新调用
+	err = client.Call("Stuck.Wait", 2, &reply)
+	_assert(CodeOf(err) == Unavailable && IsShed(err), "expect call rejected, got %v", err)
+
+	// 其他方法不受影响, 超时后 ctx 被取消
+	err = client.Call("Stuck.Context", 3, &reply)
+	_assert(CodeOf(err) == DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	select {
+	case err := <-stuck.canceled:
+		_assert(err == context.DeadlineExceeded, "expect handler ctx deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx was not canceled")
+	}
+
+	// 放弃的调用结束后恢复
+	close(stuck.release)
+	deadline := time.Now().Add(time.Second)
+	for server.NumAbandoned() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(server.NumAbandoned() == 0, "abandoned handler should be released, got %d", server.NumAbandoned())
+	err = client.Call("Stuck.Wait", 4, &reply)
+	_assert(err == nil && reply == 4, "expect call to succeed, got %v", err)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	stuck := &Stuck{release: make(chan struct{}), canceled: make(chan error, 1)}
+	addr := startTestServer(t, "mem", server, stuck)
+	busy, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = busy.Close() }()
+	idle, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = idle.Close() }()
+
+	var reply int
+	call := busy.Go("Stuck.Wait", 1, &reply, make(chan *Call, 1))
+	deadline := time.Now().Add(time.Second)
+	for server.metrics().inFlight.With("Stuck.Wait").Value() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+
+	// 空闲的连接立即关闭, 正在处理的调用不受影响
+	deadline = time.Now().Add(time.Second)
+	for idle.IsAvalable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(!idle.IsAvalable(), "idle connection should be closed")
+	// 收到 goaway 的客户端不再发出新的调用
+	for busy.IsAvalable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	err = busy.Call("Stuck.Wait", 1, &reply)
+	_assert(IsShed(err) && CodeOf(err) == Unavailable, "expect shed Unavailable after goaway, got %v", err)
+	// 关闭正在排空的客户端不会中断还在等待回复的调用
+	_assert(busy.Close() == nil, "close draining client failed")
+	_, err = Dial("mem", addr)
+	_assert(err != nil, "expect dial to fail after shutdown")
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before the call finished: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(stuck.release)
+	<-call.Done
+	_assert(call.Error == nil && reply == 1, "expect call to finish, got %v", call.Error)
+	select {
+	case err := <-done:
+		_assert(err == nil, "expect shutdown to succeed, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not return")
+	}
+}
+
+// 不认识 goaway 的客户端: goaway 之后才到达的请求被标记为 Shed 拒绝, 连接在空闲 goAwayGrace 后关闭
+func TestServer_ShutdownGoAway(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	addr := startTestServer(t, "mem", server, new(Echo))
+	conn, err := memconn.Dial(addr, time.Second)
+	_assert(err == nil, "dial failed: %v", err)
+	opt, _ := parseOptions()
+	cc, _, _, err := clientHandshake(conn, opt)
+	_assert(err == nil, "handshake failed: %v", err)
+	defer func() { _ = cc.Close() }()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && isGoAway(&h), "expect goaway, got %+v", h)
+	_assert(cc.ReadBody(nil) == nil, "read goaway body failed")
+
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Echo.Echo", Seq: 1}, "hi") == nil, "write request failed")
+	h = codec.Header{}
+	_assert(cc.ReadHeader(&h) == nil, "expect a reply")
+	err = headerError(&h)
+	_assert(h.Seq == 1 && CodeOf(err) == Unavailable && IsShed(err), "expect shed Unavailable, got %+v", h)
+	_assert(cc.ReadBody(nil) == nil, "read reply body failed")
+
+	_assert(cc.ReadHeader(&h) != nil, "expect connection to be closed")
+	select {
+	case err := <-done:
+		_assert(err == nil, "expect shutdown to succeed, got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	_assert(time.Since(start) >= goAwayGrace, "idle connection closed before goAwayGrace")
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	stuck := &Stuck{release: make(chan struct{}), canceled: make(chan error, 1)}
+	defer close(stuck.release)
+	client, err := Dial("mem", startTestServer(t, "mem", server, stuck))
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Stuck.Wait", 1, new(int), make(chan *Call, 1))
+	deadline := time.Now().Add(time.Second)
+	for server.metrics().inFlight.With("Stuck.Wait").Value() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(err == context.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	// 连接被强制关闭
+	<-call.Done
+	_assert(call.Error != nil, "expect call to fail after forced shutdown")
+}
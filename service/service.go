@@ -1,26 +1,36 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
 	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
 )
 
 /***********服务注册***************/
 
 // 一个具体的方法
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64 // 统计调用次数
+	method     reflect.Method
+	ArgType    reflect.Type
+	ReplyType  reflect.Type
+	hasContext bool   // 第一个参数是否为 context.Context
+	idempotent bool   // 是否幂等, 见 IdempotentMethods
+	numCalls   uint64 // 统计调用次数
+	abandoned  int64  // 已经超时或取消、但还在执行的调用数, 见 callWithTimeout
 }
 
 func (m *methodType) GetNumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+func (m *methodType) NumAbandoned() int64 {
+	return atomic.LoadInt64(&m.abandoned)
+}
+
 // 根据调用方法返回其输入输出参数类型
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
@@ -48,46 +58,111 @@ type service struct {
 	typ      reflect.Type  // reciver的type
 	receiver reflect.Value // 结构体本身，保留是因为在调用时需要作为第 0 个参数
 	method   map[string]*methodType
+	skipped  map[string]error // 没有通过检查的方法及原因, Register 时返回错误
+}
+
+// 服务实现 IdempotentMethods 来声明哪些方法是幂等的, 服务端在握手时把它们告诉客户端,
+// 客户端只会自动重试幂等的方法
+type IdempotentMethods interface {
+	IdempotentMethods() []string
 }
 
-func newService(rcvr interface{}) *service {
+// 注册方法时的额外检查, 不通过的方法不会被注册
+type methodCheck func(argType, replyType reflect.Type) error
+
+// 服务名不是导出的名字时返回错误
+func newService(rcvr interface{}, checks ...methodCheck) (*service, error) {
+	// Indirect: If s.rcvr is not a pointer, Indirect returns s.rcvr.
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) {
+		return nil, fmt.Errorf("rpc server: %q is not a valid service name", name)
+	}
+	return newNamedService(name, rcvr, checks...), nil
+}
+
+// 内置服务 (如 _Reflection) 的名字不受导出规则限制
+func newNamedService(name string, rcvr interface{}, checks ...methodCheck) *service {
 	s := new(service)
 	s.receiver = reflect.ValueOf(rcvr)
-	// Indirect: If s.rcvr is not a pointer, Indirect returns s.rcvr.
-	s.name = reflect.Indirect(s.receiver).Type().Name()
+	s.name = name
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	s.registerMethod(checks...)
+	if im, ok := rcvr.(IdempotentMethods); ok {
+		for _, name := range im.IdempotentMethods() {
+			if m := s.method[name]; m != nil {
+				m.idempotent = true
+			}
+		}
 	}
-	s.registerMethod()
 	return s
 }
 
 // registerMethods 过滤出了符合条件的方法：
 // - 两个导出或内置类型的入参（反射时为 3 个，第 0 个是自身，类似于 python 的 self，java 中的 this）
+// - 或者在这两个入参前多一个 context.Context, 用来获取对端信息 (PeerFromContext) 和调用时限
 // - 返回值有且只有 1 个，类型为 error
-func (s *service) registerMethod() {
+// - 通过所有 checks 的检查
+func (s *service) registerMethod(checks ...methodCheck) {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
 		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		var hasContext bool
+		switch {
+		case mType.NumIn() == 3:
+		case mType.NumIn() == 4 && mType.In(1) == typeOfContext:
+			hasContext = true
+		default:
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
+		if err := runMethodChecks(checks, argType, replyType); err != nil {
+			if s.skipped == nil {
+				s.skipped = make(map[string]error)
+			}
+			s.skipped[method.Name] = err
+			continue
+		}
 		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			method:     method,
+			ArgType:    argType,
+			ReplyType:  replyType,
+			hasContext: hasContext,
+		}
+	}
+}
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+func runMethodChecks(checks []methodCheck, argType, replyType reflect.Type) error {
+	for _, check := range checks {
+		if err := check(argType, replyType); err != nil {
+			return err
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
+	return nil
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// 只接受 protobuf 编码时, 参数和返回值 (或其指针) 必须实现 proto.Message
+func checkProtoMessage(argType, replyType reflect.Type) error {
+	if !argType.Implements(protoMessageType) && !reflect.PointerTo(argType).Implements(protoMessageType) {
+		return fmt.Errorf("args type %s does not implement proto.Message", argType)
+	}
+	if !replyType.Implements(protoMessageType) {
+		return fmt.Errorf("reply type %s does not implement proto.Message", replyType)
+	}
+	return nil
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
@@ -96,9 +171,18 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 
 /***********通过反射值调用方法**************/
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// ctx 只会传给第一个参数为 context.Context 的方法
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.receiver, argv, replyv})
+	in := []reflect.Value{s.receiver, argv, replyv}
+	if m.hasContext {
+		in = []reflect.Value{s.receiver, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -4,6 +4,7 @@ import (
 	"GeeRPC/foo"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -15,7 +16,8 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var footest foo.Foo
-	s := newService(&footest)
+	s, err := newService(&footest)
+	_assert(err == nil, "newService failed: %v", err)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
@@ -23,7 +25,7 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var footest foo.Foo
-	s := newService(&footest)
+	s, _ := newService(&footest)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
@@ -32,3 +34,12 @@ func TestMethodType_Call(t *testing.T) {
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.GetNumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type unexported int
+
+func (unexported) Get(args int, reply *int) error { return nil }
+
+func TestServer_RegisterUnexported(t *testing.T) {
+	err := NewServer().Register(new(unexported))
+	_assert(err != nil && strings.Contains(err.Error(), "not a valid service name"), "expect invalid name error, got %v", err)
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 优雅关闭: Shutdown 关闭所有 Accept 中的 listener, 不再接受新连接, 并在每条连接上发送 goaway 帧;
+// 客户端收到 goaway 后不再发出新的调用, 已经发出的调用都回复后关闭连接
+// 服务端在连接读到 EOF 后释放它; 不认识 goaway 的客户端, 连接空闲超过 goAwayGrace 后由服务端关闭,
+// 这样 goaway 之前已经在路上的请求仍然会被读到, 以 Unavailable 拒绝并标记为 Shed, 客户端可以安全地换一个节点重试
+// goaway 帧与心跳帧相同: Seq 为 0, body 为空, 旧的客户端按未知的回复丢弃
+// HTTP 网关和 WebSocketHandler 所在的 http.Server 需要单独 Shutdown
+
+const goAwayMethod = "_geerpc.GoAway"
+
+var errServerShutdown = &Error{Code: Unavailable, Message: "rpc server: server is shutting down", Shed: true}
+
+const (
+	// 检查连接是否空闲的间隔
+	shutdownPollInterval = 10 * time.Millisecond
+	// 发送 goaway 后等待客户端关闭空闲连接的时间
+	goAwayGrace = time.Second
+)
+
+func isGoAway(h *codec.Header) bool {
+	return h.Seq == 0 && h.ServiceMethod == goAwayMethod
+}
+
+func (server *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&server.inShutdown) != 0
+}
+
+// 记录 Accept 中的 listener, 已经在关闭时返回 false
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.shuttingDown() {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// 记录握手完成的连接, 已经在关闭时返回 false
+func (server *Server) trackConn(cc codec.Codec, state *connState, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, cc)
+		return true
+	}
+	if server.shuttingDown() {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[codec.Codec]*connState)
+	}
+	server.conns[cc] = state
+	return true
+}
+
+// 在所有连接上发送 goaway; 写可能阻塞, 在各自的协程中写, 不会持有 server.mu 等待
+func (server *Server) goAway() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	now := time.Now()
+	for cc, state := range server.conns {
+		state.goAwayAt = now
+		go func(cc codec.Codec) {
+			_ = cc.Write(&codec.Header{ServiceMethod: goAwayMethod}, invalidRequest)
+		}(cc)
+	}
+}
+
+// 关闭发送 goaway 后空闲超过 goAwayGrace 的连接, force 时关闭所有连接; 返回是否所有连接都已经退出
+func (server *Server) closeConns(force bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for cc, state := range server.conns {
+		if force || state.idleFor(0) && time.Since(state.goAwayAt) >= goAwayGrace {
+			_ = cc.Close()
+		}
+	}
+	return len(server.conns) == 0
+}
+
+// Shutdown 优雅地关闭服务器, 所有连接退出后返回 nil;
+// ctx 先结束时强制关闭剩余的连接并返回 ctx.Err(), 这些连接上还在执行的方法不会被中断
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	atomic.StoreInt32(&server.inShutdown, 1)
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	server.mu.Unlock()
+	// 设置 inShutdown 之后不会再有新的连接, 每条连接都会收到 goaway
+	server.goAway()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if server.closeConns(false) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			server.closeConns(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"GeeRPC/codec"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Code 是调用结果的错误码, 取值与 gRPC 相同, 随回复的 header 一起发送
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = [...]string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound", "AlreadyExists",
+	"PermissionDenied", "ResourceExhausted", "FailedPrecondition", "Aborted", "OutOfRange",
+	"Unimplemented", "Internal", "Unavailable", "DataLoss", "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// HTTPStatus 返回 HTTP 网关使用的状态码
+func (c Code) HTTPStatus() int {
+	switch c {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499 // client closed request
+	case InvalidArgument, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound, Unimplemented:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error 是带错误码的错误, 服务方法可以直接返回它来指定错误码
+type Error struct {
+	Code       Code
+	Message    string
+	RetryAfter time.Duration // 建议客户端至少等待多久再重试, 0 表示没有建议, 随回复 header 的 retry-after 发送
+	Shed       bool          // 请求被服务端丢弃, 没有执行, 随回复 header 的 shed 发送
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Errorf 创建带错误码的错误
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// 回复 header 中错误附带的 metadata 键
+const (
+	MetadataRetryAfter = "retry-after" // Error.RetryAfter, 值为 time.Duration 的字符串形式
+	MetadataShed       = "shed"        // Error.Shed, 值为 "true"
+)
+
+// 错误附带的信息写入回复 header 的 metadata, 没有时返回 nil
+func errorMetadata(err error) map[string]string {
+	var e *Error
+	if !errors.As(err, &e) || e.RetryAfter <= 0 && !e.Shed {
+		return nil
+	}
+	md := make(map[string]string)
+	if e.RetryAfter > 0 {
+		md[MetadataRetryAfter] = e.RetryAfter.String()
+	}
+	if e.Shed {
+		md[MetadataShed] = "true"
+	}
+	return md
+}
+
+// IsShed 判断请求是否被服务端的过载保护丢弃, 这样的请求没有执行, 可以安全地重试
+func IsShed(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Shed
+}
+
+// RetryAfterOf 返回 err 中服务端建议的重试等待时间, 没有时返回 0
+func RetryAfterOf(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// CodeOf 返回 err 的错误码, nil 为 OK, 不带错误码的错误为 Unknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, ErrShutdown), isConnError(err):
+		return Unavailable
+	}
+	// 请求已经发出后的读写超时, 对端可能已经执行, 不能当作连接错误重试
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DeadlineExceeded
+	}
+	return Unknown
+}
+
+// 连接断开或无法建立, 这类错误可以换一个连接重试
+// 其他 net.OpError (如读写超时) 不算, 只有建立连接失败的 dial 错误才能确定请求没有发出
+func isConnError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, codec.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCodeOf_NetErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code Code
+	}{
+		{"dial refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, Unavailable},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, Unavailable},
+		{"read reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, Unavailable},
+		// 请求可能已经送达, 不能当作连接错误重试
+		{"read timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, DeadlineExceeded},
+		{"write timeout", &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, DeadlineExceeded},
+		{"other op error", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("boom")}, Unknown},
+	}
+	for _, c := range cases {
+		_assert(CodeOf(c.err) == c.code, "%s: expect %v, got %v", c.name, c.code, CodeOf(c.err))
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"GeeRPC/trace"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Meta.Get 返回请求 metadata 中 key 对应的值
+type Meta int
+
+func (Meta) Get(ctx context.Context, key string, reply *string) error {
+	md, _ := MetadataFromContext(ctx)
+	*reply = md[key]
+	if *reply == "" {
+		return Errorf(NotFound, "no metadata %q", key)
+	}
+	return nil
+}
+
+func TestServer_Tracing(t *testing.T) {
+	t.Parallel()
+	serverSpans, clientSpans := new(trace.InMemoryExporter), new(trace.InMemoryExporter)
+	server := &Server{Tracer: &trace.Tracer{Exporter: serverSpans}}
+	addr := startTestServer(t, "mem", server, new(Meta))
+	client, err := Dial("mem", addr, &Option{Tracer: &trace.Tracer{Exporter: clientSpans}})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.CallContext(context.Background(), "Meta.Get", "user", &reply, WithMetadata(map[string]string{"user": "alice"}))
+	_assert(err == nil && reply == "alice", "expect metadata to reach the server, got %q, %v", reply, err)
+	err = client.CallContext(context.Background(), "Meta.Get", "missing", &reply)
+	_assert(CodeOf(err) == NotFound, "expect NotFound, got %v", err)
+
+	cs, ss := clientSpans.Spans(), serverSpans.Spans()
+	_assert(len(cs) == 2 && len(ss) == 2, "expect 2 client and 2 server spans, got %d and %d", len(cs), len(ss))
+	for i := range cs {
+		c, s := cs[i], ss[i]
+		_assert(c.Kind == trace.SpanKindClient && s.Kind == trace.SpanKindServer, "unexpected span kinds %s, %s", c.Kind, s.Kind)
+		_assert(s.TraceID == c.TraceID && s.Parent == c.SpanID, "server span should be a child of the client span")
+		_assert(!c.Parent.IsValid(), "client span should be a root span")
+		_assert(s.Name == "Meta.Get" && s.Attributes["rpc.method"] == "Meta.Get", "unexpected server span %+v", s)
+		_assert(s.Attributes["net.peer.addr"] != "" && c.Attributes["net.peer.addr"] == "mem@"+addr, "expect peer attributes")
+	}
+	_assert(ss[0].Status == "OK" && ss[1].Status == "NotFound" && cs[1].Status == "NotFound", "unexpected status %s, %s", ss[0].Status, ss[1].Status)
+	_assert(cs[0].TraceID != cs[1].TraceID, "each root call should start a new trace")
+}
+
+func TestServer_TracingPropagation(t *testing.T) {
+	t.Parallel()
+	spans := new(trace.InMemoryExporter)
+	server := &Server{Tracer: &trace.Tracer{Exporter: spans}}
+	addr := startTestServer(t, "mem", server, new(Meta))
+	// 客户端没有 Tracer 时仍然传递 ctx 中的上下文
+	client, err := Dial("mem", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, parent := (&trace.Tracer{}).Start(context.Background(), "parent", trace.SpanKindInternal)
+	var reply string
+	err = client.CallContext(ctx, "Meta.Get", trace.TraceparentKey, &reply)
+	_assert(err == nil && reply == parent.Traceparent(), "expect traceparent %s, got %q, %v", parent.Traceparent(), reply, err)
+
+	// HTTP 网关从 header 中取出 traceparent
+	ts := httptest.NewServer(server.RESTHandler())
+	defer ts.Close()
+	req, _ := http.NewRequest("POST", ts.URL+DefaultRESTPath+"Meta/Get", strings.NewReader(`"x"`))
+	req.Header.Set(trace.TraceparentKey, parent.Traceparent())
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "post failed: %v", err)
+	_ = resp.Body.Close()
+
+	got := spans.Spans()
+	_assert(len(got) == 2, "expect 2 server spans, got %d", len(got))
+	for _, s := range got {
+		_assert(s.TraceID == parent.TraceID && s.Parent == parent.SpanID, "server span should be a child of %s", parent.Traceparent())
+	}
+}
+
+// Call 和 Go 没有 ctx, 设置了 Tracer 时同样创建 client span 并把它传给服务端
+func TestClient_TracingCallAndGo(t *testing.T) {
+	t.Parallel()
+	spans := new(trace.InMemoryExporter)
+	client, err := Dial("mem", startTestServer(t, "mem", NewServer(), new(Meta)), &Option{Tracer: &trace.Tracer{Exporter: spans}})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var viaCall, viaGo string
+	err = client.Call("Meta.Get", trace.TraceparentKey, &viaCall)
+	_assert(err == nil, "call failed: %v", err)
+	call := <-client.Go("Meta.Get", trace.TraceparentKey, &viaGo, make(chan *Call, 1)).Done
+	_assert(call.Error == nil, "go failed: %v", call.Error)
+
+	got := spans.Spans()
+	_assert(len(got) == 2, "expect 2 client spans, got %d", len(got))
+	for i, tp := range []string{viaCall, viaGo} {
+		_assert(got[i].Kind == trace.SpanKindClient && got[i].Status == "OK", "unexpected span %+v", got[i])
+		_assert(tp == got[i].Traceparent(), "expect traceparent %s, got %q", got[i].Traceparent(), tp)
+	}
+}
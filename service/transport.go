@@ -0,0 +1,68 @@
+package service
+
+import (
+	"GeeRPC/memconn"
+	"GeeRPC/websocket"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 建立到服务端的连接, 除了 net.Dial 支持的网络外还支持:
+//   - "ws": addr 为 ws://host:port/path, 通过 WebSocket 传输
+//   - "mem": addr 为 memconn.Listen 的名字, 进程内传输, 用于测试
+//
+// tlsConfig 不为空时在 tcp 和 unix 连接上使用 TLS, 服务端用 tls.Listen 监听即可
+func dialConn(network, addr string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig != nil {
+		switch network {
+		case "ws", memconn.Network:
+			return nil, fmt.Errorf("rpc client: TLS is not supported on %s transport", network)
+		}
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, addr, tlsConfig)
+	}
+	switch network {
+	case "ws":
+		return websocket.Dial(addr, timeout)
+	case memconn.Network:
+		return memconn.Dial(addr, timeout)
+	default:
+		return net.DialTimeout(network, addr, timeout)
+	}
+}
+
+// WebSocket 传输的默认路径
+const DefaultWebSocketPath = "/_geerpc_/ws"
+
+// WebSocketHandler 返回把 HTTP 请求升级为 WebSocket 的 http.Handler,
+// 升级后的连接与 TCP 连接一样交给 ServeConn 处理, 浏览器可以直接连接:
+//
+//	http.Handle(service.DefaultWebSocketPath, server.WebSocketHandler())
+//
+// 默认只接受同源的浏览器请求, 跨域访问需要设置 CheckOrigin
+func (server *Server) WebSocketHandler() *WebSocketHandler {
+	return &WebSocketHandler{server: server}
+}
+
+type WebSocketHandler struct {
+	server *Server
+	// 检查请求的 Origin, 为空时使用 websocket.SameOrigin
+	CheckOrigin func(r *http.Request) bool
+}
+
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := &websocket.Upgrader{CheckOrigin: h.CheckOrigin}
+	conn, err := u.Upgrade(w, r)
+	if err != nil {
+		h.server.logger().Warn("rpc server: websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	h.server.ServeConn(conn)
+}
+
+// DialWebSocket 通过 WebSocket 连接 rpc server, url 形如 ws://host:port/_geerpc_/ws
+func DialWebSocket(url string, opts ...*Option) (*Client, error) {
+	return Dial("ws", url, opts...)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"GeeRPC/foo"
+	"GeeRPC/memconn"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_WebSocket(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var f foo.Foo
+	_ = server.Register(&f)
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+
+	client, err := DialWebSocket("ws" + strings.TrimPrefix(ts.URL, "http") + DefaultWebSocketPath)
+	_assert(err == nil, "dial websocket failed: %v", err)
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 3; i++ {
+		var reply int
+		err := client.Call("Foo.Sum", &foo.Args{Num1: i, Num2: i * i}, &reply)
+		_assert(err == nil && reply == i+i*i, "call over websocket failed: %v", err)
+	}
+}
+
+func TestServer_MemTransport(t *testing.T) {
+	t.Parallel()
+	const latency = 20 * time.Millisecond
+	l, err := memconn.Listen("", &memconn.Options{Latency: latency})
+	_assert(err == nil, "listen mem failed: %v", err)
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	var f foo.Foo
+	_ = server.Register(&f)
+	go server.Accept(l)
+
+	client, err := Dial("mem", l.Addr().String())
+	_assert(err == nil, "dial mem failed: %v", err)
+	defer func() { _ = client.Close() }()
+	start := time.Now()
+	var reply int
+	err = client.Call("Foo.Sum", &foo.Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call over mem failed: %v", err)
+	// 一次调用往返至少两倍的单向延迟
+	_assert(time.Since(start) >= 2*latency, "expect round trip of at least %s, got %s", 2*latency, time.Since(start))
+
+	_, err = Dial("mem", "no-such-listener")
+	_assert(errors.Is(err, memconn.ErrNoListener), "expect ErrNoListener, got %v", err)
+}
+
+type Whoami int
+
+// 返回调用方的 uid, 用于检查 SO_PEERCRED 是否传给了服务方法
+func (w Whoami) UID(ctx context.Context, args int, reply *int) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.Cred == nil {
+		return Errorf(PermissionDenied, "no peer credentials")
+	}
+	*reply = int(p.Cred.UID)
+	return nil
+}
+
+func TestListen_Unix(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "geerpc.sock")
+
+	// 模拟进程异常退出后残留的 socket 文件
+	l, err := Listen("unix", path)
+	_assert(err == nil, "listen failed: %v", err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	_, err = os.Stat(path)
+	_assert(err == nil, "socket file should be left behind")
+
+	l, err = Listen("unix", path)
+	_assert(err == nil, "listen should remove the stale socket: %v", err)
+	defer func() { _ = l.Close() }()
+	_, err = Listen("unix", path)
+	_assert(err != nil && strings.Contains(err.Error(), "in use"), "expect an in use error, got %v", err)
+
+	server := NewServer()
+	var w Whoami
+	_ = server.Register(&w)
+	go server.Accept(l)
+	client, err := Dial("unix", path)
+	_assert(err == nil, "dial unix failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var uid int
+	err = client.Call("Whoami.UID", 0, &uid)
+	_assert(err == nil && uid == os.Getuid(), "expect uid %d, got %d (%v)", os.Getuid(), uid, err)
+
+	// 非 unix socket 没有身份信息
+	tcpClient, err := Dial("tcp", startTestServer(t, "tcp", NewServer(), new(Whoami)))
+	_assert(err == nil, "dial tcp failed: %v", err)
+	defer func() { _ = tcpClient.Close() }()
+	err = tcpClient.Call("Whoami.UID", 0, &uid)
+	_assert(CodeOf(err) == PermissionDenied, "expect PermissionDenied over tcp, got %v", err)
+}
+
+func TestListen_AbstractUnix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are linux only")
+	}
+	t.Parallel()
+	addr := fmt.Sprintf("@geerpc-test-%d", os.Getpid())
+	l, err := Listen("unix", addr)
+	_assert(err == nil, "listen abstract failed: %v", err)
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	_ = server.Register(new(foo.Foo))
+	go server.Accept(l)
+
+	client, err := Dial("unix", addr)
+	_assert(err == nil, "dial abstract failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &foo.Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call over abstract socket failed: %v", err)
+}
+
+func TestServer_TLS(t *testing.T) {
+	t.Parallel()
+	// 借用 httptest 自带的证书, 对 127.0.0.1 有效
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", ts.TLS.Clone())
+	_assert(err == nil, "listen tls failed: %v", err)
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	_ = server.Register(new(foo.Foo))
+	go server.Accept(l)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	client, err := Dial("tcp", l.Addr().String(), &Option{TLSConfig: &tls.Config{RootCAs: pool}})
+	_assert(err == nil, "dial tls failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &foo.Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "call over tls failed: %v", err)
+
+	// 不信任服务端证书时握手失败
+	_, err = Dial("tcp", l.Addr().String(), &Option{TLSConfig: &tls.Config{}})
+	_assert(err != nil, "expect untrusted certificate to be rejected")
+}
+
+// unix socket 上的 TLS 连接同样能拿到对端身份
+func TestServer_TLSOverUnix(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "geerpc.sock")
+	ul, err := Listen("unix", path)
+	_assert(err == nil, "listen unix failed: %v", err)
+	l := tls.NewListener(ul, ts.TLS.Clone())
+	defer func() { _ = l.Close() }()
+	server := NewServer()
+	_ = server.Register(new(Whoami))
+	go server.Accept(l)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	// httptest 的证书对 example.com 有效
+	client, err := Dial("unix", path, &Option{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}})
+	_assert(err == nil, "dial tls over unix failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var uid int
+	err = client.Call("Whoami.UID", 0, &uid)
+	_assert(err == nil && uid == os.Getuid(), "expect uid %d, got %d (%v)", os.Getuid(), uid, err)
+}
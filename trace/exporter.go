@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// JSONExporter 把每个 span 编码为一行 JSON 写入 W
+type JSONExporter struct {
+	// 记录编码失败等错误, 为空时使用 slog.Default()
+	Logger *slog.Logger
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// NewStdoutExporter 返回写到标准输出的 JSONExporter
+func NewStdoutExporter() *JSONExporter {
+	return NewJSONExporter(os.Stdout)
+}
+
+func (e *JSONExporter) logger() *slog.Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return slog.Default()
+}
+
+func (e *JSONExporter) Export(span *Span) {
+	b, err := json.Marshal(span)
+	if err != nil {
+		e.logger().Warn("trace: encode span failed", "span", span.Name, "err", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = e.w.Write(append(b, '\n'))
+}
+
+// InMemoryExporter 把 span 保存在内存中, 用于测试
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans 按结束的顺序返回所有 span
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind 表示 span 在调用中的角色
+type SpanKind string
+
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindClient   SpanKind = "client"
+	SpanKindServer   SpanKind = "server"
+)
+
+// Span 是一次调用中的一段, 结束后交给 Exporter
+type Span struct {
+	SpanContext
+	Name       string            `json:"name"`
+	Kind       SpanKind          `json:"kind"`
+	Parent     SpanID            `json:"parent_span_id"` // 根 span 为全 0
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Status     string            `json:"status"`          // 状态码, 例如 OK, Unavailable
+	Error      string            `json:"error,omitempty"` // 失败时的错误信息
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SetAttribute 设置一个属性, 可以在 Finish 之前随时调用
+// Finish 之后 span 已经交给 Exporter, 设置的属性被忽略, Exporter 可以不加锁地读取 span
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// Finish 记录结束时间和状态并导出, 只有第一次调用有效
+func (s *Span) Finish(status string, err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.Status = status
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if s.Sampled && s.tracer != nil && s.tracer.Exporter != nil {
+		s.tracer.Exporter.Export(s)
+	}
+}
+
+// Exporter 输出结束的 span, 需要可以并发调用
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer 创建 span, span 结束后交给 Exporter
+type Tracer struct {
+	Exporter Exporter
+}
+
+// Start 在 ctx 中的上下文下创建一个 span, 没有上下文时开始一个新的 trace
+// 返回的 ctx 带有新的 span, 用它发起的调用会把 span 传给对端
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		Name:   name,
+		Kind:   kind,
+		Start:  time.Now(),
+		tracer: t,
+	}
+	span.SpanID = newSpanID()
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.TraceID = parent.TraceID
+		span.Parent = parent.SpanID
+		span.Sampled = parent.Sampled
+	} else {
+		span.TraceID = newTraceID()
+		span.Sampled = true
+	}
+	return ContextWithSpan(ctx, span), span
+}
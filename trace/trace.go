@@ -0,0 +1,120 @@
+// Package trace 实现跨进程的调用链追踪, 上下文按 W3C Trace Context 的 traceparent 格式传递:
+//
+//	traceparent: 00-<32 位十六进制 trace id>-<16 位十六进制 span id>-<2 位十六进制 flags>
+//
+// 客户端把当前 span 写入请求的 metadata, 服务端取出后以它为父 span 创建新的 span,
+// span 结束后交给 Exporter 输出, 见 service.Server.Tracer 和 service.Option.Tracer
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// TraceparentKey 是 metadata 和 HTTP header 中 traceparent 的键
+const TraceparentKey = "traceparent"
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+func (t TraceID) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+func (s SpanID) MarshalText() ([]byte, error)  { return []byte(s.String()), nil }
+
+func newTraceID() (t TraceID) {
+	_, _ = rand.Read(t[:])
+	return
+}
+
+func newSpanID() (s SpanID) {
+	_, _ = rand.Read(s[:])
+	return
+}
+
+// SpanContext 是需要跨进程传递的部分
+type SpanContext struct {
+	TraceID TraceID `json:"trace_id"`
+	SpanID  SpanID  `json:"span_id"`
+	Sampled bool    `json:"sampled"` // 是否记录, 父 span 不记录时子 span 也不记录, 但上下文照常传递
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent 返回 traceparent 格式的字符串
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+var ErrInvalidTraceparent = errors.New("trace: invalid traceparent")
+
+// ParseTraceparent 解析 traceparent, 未来的版本只读取前四个字段
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	var version [1]byte
+	if len(parts) < 4 || !decodeHex(version[:], parts[0]) || version[0] == 0xff ||
+		version[0] == 0 && len(parts) != 4 {
+		return sc, ErrInvalidTraceparent
+	}
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// 只接受小写的十六进制, 与规范一致
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan 返回带有 span 的 ctx, 之后在 ctx 上创建的 span 都是它的子 span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 返回 ctx 中当前的 span
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// ContextWithRemote 记录从对端收到的上下文, 作为下一个 span 的父 span
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前的上下文: 本地的 span 优先, 其次是对端传来的
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span, ok := SpanFromContext(ctx); ok {
+		return span.SpanContext, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const s = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(s)
+	if err != nil || !sc.Sampled || sc.Traceparent() != s {
+		t.Fatalf("failed to parse %s: %+v, %v", s, sc, err)
+	}
+	if sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); err != nil || sc.Sampled {
+		t.Fatalf("future versions should be accepted: %+v, %v", sc, err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Fatalf("expect %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := new(InMemoryExporter)
+	tracer := &Tracer{Exporter: exporter}
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindInternal)
+	_, child := tracer.Start(ctx, "child", SpanKindClient)
+	child.Finish("OK", nil)
+	child.Finish("Unknown", nil)
+	root.Finish("OK", nil)
+	spans := exporter.Spans()
+	if len(spans) != 2 || spans[0] != child || spans[1] != root {
+		t.Fatalf("expect child then root to be exported once, got %d spans", len(spans))
+	}
+	if child.TraceID != root.TraceID || child.Parent != root.SpanID || root.Parent.IsValid() {
+		t.Fatal("child span should inherit the trace of its parent")
+	}
+
+	// 父 span 不记录时子 span 也不记录
+	ctx = ContextWithRemote(context.Background(), SpanContext{TraceID: root.TraceID, SpanID: root.SpanID})
+	_, span := tracer.Start(ctx, "unsampled", SpanKindServer)
+	span.Finish("OK", nil)
+	if span.Sampled || len(exporter.Spans()) != 2 {
+		t.Fatal("unsampled span should not be exported")
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	_, span := (&Tracer{Exporter: NewJSONExporter(&buf)}).Start(context.Background(), "Foo.Sum", SpanKindServer)
+	span.SetAttribute("rpc.method", "Foo.Sum")
+	span.Finish("OK", nil)
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["trace_id"] != span.TraceID.String() || got["name"] != "Foo.Sum" || got["status"] != "OK" {
+		t.Fatalf("unexpected json: %s", buf.String())
+	}
+}
+
+func TestSpan_SetAttributeAfterFinish(t *testing.T) {
+	exporter := new(InMemoryExporter)
+	_, span := (&Tracer{Exporter: exporter}).Start(context.Background(), "span", SpanKindInternal)
+	span.SetAttribute("k", "v")
+	span.Finish("OK", nil)
+	// 导出之后的修改与 Exporter 的读取并发, 不能生效
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		span.SetAttribute("k", "changed")
+	}()
+	if b, err := json.Marshal(exporter.Spans()[0]); err != nil || !bytes.Contains(b, []byte(`"k":"v"`)) {
+		t.Fatalf("unexpected exported span %s, %v", b, err)
+	}
+	<-done
+	if v := span.Attributes["k"]; v != "v" {
+		t.Fatalf("attributes should not change after Finish, got %q", v)
+	}
+}
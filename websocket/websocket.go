@@ -0,0 +1,225 @@
+// 最小化的 WebSocket (RFC 6455) 实现, 只用于把 RPC 字节流放在 WebSocket 连接上传输:
+// 写入的数据作为二进制帧发送, 读取时把数据帧的内容拼接成连续的字节流,
+// ping 自动回复 pong, 收到 close 帧后回复 close 并返回 io.EOF
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 握手时用于计算 Sec-WebSocket-Accept 的固定 GUID
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// 帧类型
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// 控制帧的 payload 最多 125 字节
+const maxControlPayload = 125
+
+var ErrProtocol = errors.New("websocket: protocol error")
+
+// Conn 是建立好的 WebSocket 连接, 实现了 net.Conn
+// Read 只能在一个协程中调用, Write 可以并发调用
+type Conn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool // 客户端发送的帧必须加掩码
+
+	// 读状态, 只在读协程中使用
+	remaining int64   // 当前数据帧还未读取的 payload 长度
+	mask      [4]byte // 当前数据帧的掩码
+	masked    bool
+	maskPos   int
+	closed    bool // 已经收到 close 帧
+
+	wmu       sync.Mutex // 保证帧不会交织
+	closeOnce sync.Once
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func newConn(conn net.Conn, br *bufio.Reader, isClient bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, isClient: isClient}
+}
+
+// 计算握手应答中的 Sec-WebSocket-Accept
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// 读取下一个帧头, 控制帧在这里直接处理, 数据帧的 payload 留给 Read
+func (c *Conn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return err
+	}
+	opcode := hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	length := int64(hdr[1] & 0x7f)
+	// 服务端只接受加了掩码的帧, 客户端只接受没有掩码的帧
+	if masked == c.isClient || hdr[0]&0x70 != 0 {
+		return ErrProtocol
+	}
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err := io.ReadFull(c.br, l[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err := io.ReadFull(c.br, l[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(l[:]))
+		if length < 0 {
+			return ErrProtocol
+		}
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opContinuation, opText, opBinary:
+		c.remaining, c.mask, c.masked, c.maskPos = length, mask, masked, 0
+		return nil
+	case opClose, opPing, opPong:
+		// 控制帧不能分片, payload 不超过 125 字节
+		if hdr[0]&0x80 == 0 || length > maxControlPayload {
+			return ErrProtocol
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+		}
+		switch opcode {
+		case opPing:
+			return c.writeFrame(opPong, payload)
+		case opClose:
+			c.closed = true
+			_ = c.writeClose(payload)
+		}
+		return nil
+	default:
+		return ErrProtocol
+	}
+}
+
+// Write 把 p 作为一个二进制帧发送
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := make([]byte, 0, 14+len(p))
+	buf = append(buf, 0x80|opcode) // FIN
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch {
+	case len(p) <= 125:
+		buf = append(buf, maskBit|byte(len(p)))
+	case len(p) <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(p)))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(p)))
+	}
+	if c.isClient {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, p...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i&3]
+		}
+	} else {
+		buf = append(buf, p...)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *Conn) writeClose(payload []byte) error {
+	var err error
+	c.closeOnce.Do(func() { err = c.writeFrame(opClose, payload) })
+	return err
+}
+
+// Close 发送 close 帧 (状态码 1000) 后关闭底层连接
+func (c *Conn) Close() error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.writeClose([]byte{0x03, 0xe8})
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 构造一个客户端发送的 (加掩码的) 帧
+func maskedFrame(fin bool, opcode byte, payload string) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{b0, 0x80 | byte(len(payload)), mask[0], mask[1], mask[2], mask[3]}
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i&3])
+	}
+	return frame
+}
+
+// 分片的数据帧中间夹着 ping, 读取方应该拼出完整的数据并回复 pong
+func TestConn_FragmentsAndPing(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	c := newConn(server, nil, false)
+
+	go func() {
+		_, _ = client.Write(maskedFrame(false, opBinary, "hel"))
+		_, _ = client.Write(maskedFrame(true, opPing, "p"))
+		_, _ = client.Write(maskedFrame(true, opContinuation, "lo"))
+	}()
+	pong := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 3)
+		_, _ = io.ReadFull(client, b)
+		pong <- b
+	}()
+
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != "hello" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+	if b := <-pong; b[0] != 0x80|opPong || b[1] != 1 || b[2] != 'p' {
+		t.Fatalf("unexpected pong frame %v", b)
+	}
+}
+
+// 服务端必须拒绝没有掩码的帧
+func TestConn_RejectUnmasked(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	c := newConn(server, nil, false)
+	go func() { _, _ = client.Write([]byte{0x80 | opBinary, 1, 'x'}) }()
+	if _, err := c.Read(make([]byte, 1)); err != ErrProtocol {
+		t.Fatalf("expect ErrProtocol, got %v", err)
+	}
+}
+
+func upgradeStatus(t *testing.T, url, origin string) int {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUpgrade_Origin(t *testing.T) {
+	var u Upgrader
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := u.Upgrade(w, r); err == nil {
+			_ = c.Close()
+		}
+	}))
+	defer ts.Close()
+
+	same := "http://" + strings.TrimPrefix(ts.URL, "http://")
+	for _, c := range []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{same, http.StatusSwitchingProtocols},
+		{"http://evil.example", http.StatusForbidden},
+	} {
+		if got := upgradeStatus(t, ts.URL, c.origin); got != c.status {
+			t.Fatalf("origin %q: expect %d, got %d", c.origin, c.status, got)
+		}
+	}
+
+	u.CheckOrigin = func(r *http.Request) bool { return true }
+	if got := upgradeStatus(t, ts.URL, "http://evil.example"); got != http.StatusSwitchingProtocols {
+		t.Fatalf("CheckOrigin should allow any origin, got %d", got)
+	}
+}
+
+// http.Server 的 ReadTimeout 不应该影响升级后的连接
+func TestUpgrade_ClearDeadline(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = c.Close() }()
+		_, _ = io.Copy(c, c)
+	}))
+	ts.Config.ReadTimeout = 20 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	c, err := Dial("ws"+strings.TrimPrefix(ts.URL, "http"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != "ping" {
+		t.Fatalf("echo after read timeout: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,252 @@
+package xclient
+
+import (
+	"GeeRPC/service"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 熔断策略: 按服务器地址统计一个时间窗口内的失败率, 失败率过高时断开 (Open), 选择服务器时跳过它;
+// 断开 OpenTimeout 后进入半开 (HalfOpen), 只放行 HalfOpenRequests 个试探请求,
+// 全部成功则恢复 (Closed), 有一个失败就再次断开
+type BreakerPolicy struct {
+	Window           time.Duration  // 统计窗口, 每个窗口重新计数
+	MinRequests      int            // 窗口内请求数达到该值才会断开
+	FailureRatio     float64        // 窗口内失败率达到该值时断开
+	SlowCall         time.Duration  // 耗时超过该值的调用也算作失败, 0 表示不考虑耗时
+	OpenTimeout      time.Duration  // 断开多久后进入半开状态
+	HalfOpenRequests int            // 半开状态放行的试探请求数, 小于 1 时按 1 处理
+	FailureCodes     []service.Code // 算作失败的错误码, 业务错误一般不应该触发熔断
+}
+
+var DefaultBreakerPolicy = &BreakerPolicy{
+	Window:           10 * time.Second,
+	MinRequests:      10,
+	FailureRatio:     0.5,
+	OpenTimeout:      5 * time.Second,
+	HalfOpenRequests: 1,
+	FailureCodes:     []service.Code{service.Unavailable, service.DeadlineExceeded, service.Internal},
+}
+
+var ErrBreakerOpen = service.Errorf(service.Unavailable, "rpc xclient: circuit breakers of all servers are open")
+
+// 熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常
+	BreakerOpen                         // 断开, 不发送请求
+	BreakerHalfOpen                     // 半开, 只发送试探请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStatus 是一个服务器熔断器的快照, 用于监控
+type BreakerStatus struct {
+	Addr       string
+	State      BreakerState
+	Requests   int           // 当前窗口的请求数
+	Failures   int           // 当前窗口的失败数
+	AvgLatency time.Duration // 当前窗口的平均耗时
+	Since      time.Time     // 进入当前状态的时间
+}
+
+type breaker struct {
+	p  *BreakerPolicy
+	mu sync.Mutex
+
+	state       BreakerState
+	since       time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	latency     time.Duration // 窗口内的总耗时
+	trials      int           // 半开状态已经放行的试探请求
+	successes   int           // 半开状态成功的试探请求
+}
+
+func newBreaker(p *BreakerPolicy) *breaker {
+	now := time.Now()
+	return &breaker{p: p, since: now, windowStart: now}
+}
+
+func (b *breaker) halfOpenRequests() int {
+	if b.p.HalfOpenRequests < 1 {
+		return 1
+	}
+	return b.p.HalfOpenRequests
+}
+
+// 必须持有锁
+func (b *breaker) setState(s BreakerState, now time.Time) {
+	b.state, b.since = s, now
+	b.windowStart = now
+	b.requests, b.failures, b.latency = 0, 0, 0
+	b.trials, b.successes = 0, 0
+}
+
+// 是否允许发送请求, 允许时调用方必须在请求结束后调用 done
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == BreakerOpen && now.Sub(b.since) >= b.p.OpenTimeout {
+		b.setState(BreakerHalfOpen, now)
+	}
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trials >= b.halfOpenRequests() {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+func (b *breaker) isFailure(err error, d time.Duration) bool {
+	if b.p.SlowCall > 0 && d > b.p.SlowCall {
+		return true
+	}
+	if err == nil {
+		return false
+	}
+	code := service.CodeOf(err)
+	for _, c := range b.p.FailureCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录一次请求的结果, d 是请求的耗时
+func (b *breaker) done(err error, d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	// 请求被调用方取消 (例如对冲请求落后) 时不能说明服务器的状况
+	canceled := service.CodeOf(err) == service.Canceled
+	failed := !canceled && b.isFailure(err, d)
+
+	switch b.state {
+	case BreakerHalfOpen:
+		switch {
+		case failed:
+			b.setState(BreakerOpen, now)
+		case canceled:
+			b.trials--
+		default:
+			b.successes++
+			if b.successes >= b.halfOpenRequests() {
+				b.setState(BreakerClosed, now)
+			}
+		}
+	case BreakerClosed:
+		if canceled {
+			return
+		}
+		if b.p.Window > 0 && now.Sub(b.windowStart) >= b.p.Window {
+			b.windowStart = now
+			b.requests, b.failures, b.latency = 0, 0, 0
+		}
+		b.requests++
+		b.latency += d
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.p.MinRequests && float64(b.failures) >= b.p.FailureRatio*float64(b.requests) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+}
+
+func (b *breaker) status(addr string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{Addr: addr, State: b.state, Requests: b.requests, Failures: b.failures, Since: b.since}
+	if b.state == BreakerOpen && time.Since(b.since) >= b.p.OpenTimeout {
+		// 下一个请求就会进入半开状态
+		s.State = BreakerHalfOpen
+	}
+	if b.requests > 0 {
+		s.AvgLatency = b.latency / time.Duration(b.requests)
+	}
+	return s
+}
+
+// 返回 rpcAddr 的熔断器, 没有设置 Breaker 时返回 nil
+func (xc *XClient) breakerOf(rpcAddr string) *breaker {
+	if xc.Breaker == nil {
+		return nil
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	b := xc.breakers[rpcAddr]
+	if b == nil {
+		b = newBreaker(xc.Breaker)
+		xc.breakers[rpcAddr] = b
+	}
+	return b
+}
+
+// Breakers 返回所有服务器熔断器的状态, 按地址排序
+func (xc *XClient) Breakers() []BreakerStatus {
+	xc.mu.Lock()
+	addrs := make([]string, 0, len(xc.breakers))
+	for addr := range xc.breakers {
+		addrs = append(addrs, addr)
+	}
+	xc.mu.Unlock()
+	sort.Strings(addrs)
+	status := make([]BreakerStatus, 0, len(addrs))
+	for _, addr := range addrs {
+		status = append(status, xc.breakerOf(addr).status(addr))
+	}
+	return status
+}
+
+// 按负载均衡策略选择一个熔断器允许的服务器
+// 随机选择可能一直选中断开的服务器, 尝试若干次之后按顺序找一个允许的
+func (xc *XClient) pick() (string, error) {
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil || xc.Breaker == nil {
+		return rpcAddr, err
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(servers); i++ {
+		if xc.breakerOf(rpcAddr).allow() {
+			return rpcAddr, nil
+		}
+		if rpcAddr, err = xc.d.Get(xc.mode); err != nil {
+			return "", err
+		}
+	}
+	for _, rpcAddr := range servers {
+		if xc.breakerOf(rpcAddr).allow() {
+			return rpcAddr, nil
+		}
+	}
+	return "", ErrBreakerOpen
+}
@@ -0,0 +1,113 @@
+package xclient
+
+import (
+	"GeeRPC/service"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testBreakerPolicy() *BreakerPolicy {
+	p := *DefaultBreakerPolicy
+	p.MinRequests = 4
+	p.OpenTimeout = 50 * time.Millisecond
+	return &p
+}
+
+var errUnavailable = service.Errorf(service.Unavailable, "down")
+
+func TestBreaker_States(t *testing.T) {
+	b := newBreaker(testBreakerPolicy())
+	for i := 0; i < 2; i++ {
+		b.allow()
+		b.done(nil, time.Millisecond)
+	}
+	// 业务错误不算失败
+	b.allow()
+	b.done(service.Errorf(service.NotFound, "no such key"), 0)
+	if b.status("").State != BreakerClosed {
+		t.Fatal("business errors should not trip the breaker")
+	}
+	for i := 0; i < 3; i++ {
+		b.allow()
+		b.done(errUnavailable, 0)
+	}
+	if s := b.status(""); s.State != BreakerOpen || b.allow() {
+		t.Fatalf("expect open after 3/6 failures, got %+v", s)
+	}
+
+	// 半开状态只放行一个试探请求, 失败后再次断开
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() || b.allow() {
+		t.Fatal("half-open breaker should allow exactly one trial")
+	}
+	b.done(errUnavailable, 0)
+	if b.status("").State != BreakerOpen {
+		t.Fatal("failed trial should reopen the breaker")
+	}
+
+	// 试探成功后恢复
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("expect a trial after open timeout")
+	}
+	b.done(nil, 0)
+	if s := b.status(""); s.State != BreakerClosed || s.Requests != 0 {
+		t.Fatalf("successful trial should close the breaker with fresh counts, got %+v", s)
+	}
+}
+
+func TestBreaker_SlowCall(t *testing.T) {
+	p := testBreakerPolicy()
+	p.SlowCall = 10 * time.Millisecond
+	b := newBreaker(p)
+	for i := 0; i < 4; i++ {
+		b.allow()
+		b.done(nil, 20*time.Millisecond)
+	}
+	if s := b.status(""); s.State != BreakerOpen {
+		t.Fatalf("slow calls should trip the breaker, got %+v", s)
+	}
+}
+
+func TestXClient_Breaker(t *testing.T) {
+	bad, good := startTestServer(t, "mem", service.NewServer(), &Flaky{failures: 1 << 30}), startTestServer(t, "mem", service.NewServer(), &Flaky{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{bad, good}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Breaker = testBreakerPolicy()
+	xc.Breaker.MinRequests = 2
+	xc.Breaker.OpenTimeout = time.Minute
+
+	var reply int
+	failures := 0
+	for i := 0; i < 20; i++ {
+		if err := xc.Call(context.Background(), "Flaky.Put", i, &reply); err != nil {
+			failures++
+		}
+	}
+	// 坏的服务器失败 2 次后断开, 之后的请求都发给好的服务器
+	if failures != 2 {
+		t.Fatalf("expect 2 failures before the breaker opens, got %d", failures)
+	}
+	status := xc.Breakers()
+	if len(status) != 2 {
+		t.Fatalf("expect 2 breakers, got %+v", status)
+	}
+	for _, s := range status {
+		want := BreakerClosed
+		if s.Addr == bad {
+			want = BreakerOpen
+		}
+		if s.State != want {
+			t.Fatalf("breaker of %s: expect %s, got %s", s.Addr, want, s.State)
+		}
+	}
+
+	// 所有服务器都断开时直接失败
+	_ = xc.d.Update([]string{bad})
+	err := xc.Call(context.Background(), "Flaky.Put", 1, &reply)
+	if !errors.Is(err, ErrBreakerOpen) || service.CodeOf(err) != service.Unavailable {
+		t.Fatalf("expect ErrBreakerOpen, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package xclient
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 负载均衡策略
+type SelectMode int
+
+const (
+	RandomSelect     SelectMode = iota // 随机选择
+	RoundRobinSelect                   // 轮询
+)
+
+// 服务发现, 服务地址形如 network@addr, 见 service.XDial
+type Discovery interface {
+	Refresh() error // 从注册中心更新服务列表
+	Update(servers []string) error
+	Get(mode SelectMode) (string, error)
+	GetAll() ([]string, error)
+}
+
+var ErrNoServers = errors.New("rpc discovery: no available servers")
+
+// 不需要注册中心, 服务列表由用户手动维护
+type MultiServersDiscovery struct {
+	r       *rand.Rand // 用于随机选择
+	mu      sync.RWMutex
+	servers []string
+	index   int // 轮询的位置
+}
+
+var _ Discovery = (*MultiServersDiscovery)(nil)
+
+func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery {
+	d := &MultiServersDiscovery{
+		servers: servers,
+		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	// 轮询从随机位置开始, 避免所有客户端都从第一个开始
+	d.index = d.r.Intn(math.MaxInt32 - 1)
+	return d
+}
+
+// 手动维护的列表不需要刷新
+func (d *MultiServersDiscovery) Refresh() error {
+	return nil
+}
+
+func (d *MultiServersDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers = servers
+	return nil
+}
+
+func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
+	// r 和 index 都会被修改, 需要写锁
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.servers)
+	if n == 0 {
+		return "", ErrNoServers
+	}
+	switch mode {
+	case RandomSelect:
+		return d.servers[d.r.Intn(n)], nil
+	case RoundRobinSelect:
+		s := d.servers[d.index%n]
+		d.index = (d.index + 1) % n
+		return s, nil
+	default:
+		return "", errors.New("rpc discovery: not supported select mode")
+	}
+}
+
+// 返回服务列表的拷贝
+func (d *MultiServersDiscovery) GetAll() ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	servers := make([]string, len(d.servers))
+	copy(servers, d.servers)
+	return servers, nil
+}
@@ -0,0 +1,147 @@
+package xclient
+
+import (
+	"GeeRPC/service"
+	"context"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// 对冲策略: 幂等的调用在 Delay 内没有收到回复时, 向另一个服务器发送同样的请求,
+// 采用最先成功的回复, 其余的请求被取消 (见 service.Client.CallContext)
+// 某个请求失败时等待其他还在进行的请求, 全部失败时返回最后一个错误, 是否重试由 RetryPolicy 决定
+type HedgePolicy struct {
+	Delay       time.Duration // 发送下一个对冲请求前等待的时间
+	MaxAttempts int           // 同一次调用最多发出的请求数, 包括第一个, 小于 2 时按 2 处理
+	Idempotent  []string      // 客户端认为幂等的方法 "Service.Method", 与服务端声明的取并集
+}
+
+func (p *HedgePolicy) allowlisted(serviceMethod string) bool {
+	for _, m := range p.Idempotent {
+		if m == serviceMethod {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *HedgePolicy) maxAttempts() int {
+	if p.MaxAttempts < 2 {
+		return 2
+	}
+	return p.MaxAttempts
+}
+
+// HedgeStats 统计对冲的效果, Wins/Hedges 即对冲请求胜出的比例
+type HedgeStats struct {
+	Calls  uint64 // 启用了对冲的调用数
+	Hedges uint64 // 发出的对冲请求数, 不包括每次调用的第一个请求
+	Wins   uint64 // 对冲请求先于第一个请求成功的次数
+}
+
+type hedgeStats struct {
+	calls, hedges, wins uint64
+}
+
+// HedgeStats 返回对冲的统计
+func (xc *XClient) HedgeStats() HedgeStats {
+	return HedgeStats{
+		Calls:  atomic.LoadUint64(&xc.stats.calls),
+		Hedges: atomic.LoadUint64(&xc.stats.hedges),
+		Wins:   atomic.LoadUint64(&xc.stats.wins),
+	}
+}
+
+type hedgeResult struct {
+	index int // 第几个请求, 0 是第一个
+	reply reflect.Value
+	err   error
+}
+
+// 发送对冲请求, first 和 client 是已经选好的第一个服务器
+func (xc *XClient) hedge(ctx context.Context, first string, client *service.Client, serviceMethod string, args, reply interface{}, opts []service.CallOption) error {
+	replyv := reflect.ValueOf(reply)
+	if replyv.Kind() != reflect.Ptr || replyv.IsNil() {
+		return xc.callAddr(ctx, first, client, serviceMethod, args, reply, opts)
+	}
+	atomic.AddUint64(&xc.stats.calls, 1)
+	// 返回时取消所有未完成的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	max := xc.Hedge.maxAttempts()
+	results := make(chan hedgeResult, max)
+	// 每个请求解码到各自的 reply, 胜出的再复制给调用方
+	// c 为 nil 时在新协程中连接, 连不上的服务器不会阻塞等待其他回复
+	start := func(index int, rpcAddr string, c *service.Client) {
+		rv := reflect.New(replyv.Type().Elem())
+		go func() {
+			if c == nil {
+				var err error
+				if c, err = xc.dial(ctx, rpcAddr); err != nil {
+					results <- hedgeResult{index: index, err: xc.dialFailed(ctx, rpcAddr, err)}
+					return
+				}
+			}
+			err := xc.callAddr(ctx, rpcAddr, c, serviceMethod, args, rv.Interface(), opts)
+			results <- hedgeResult{index: index, reply: rv, err: err}
+		}()
+	}
+	used := map[string]bool{first: true}
+	start(0, first, client)
+	sent, pending := 1, 1
+
+	timer := time.NewTimer(xc.Hedge.Delay)
+	defer timer.Stop()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				lastErr = r.err
+				continue
+			}
+			if r.index > 0 {
+				atomic.AddUint64(&xc.stats.wins, 1)
+			}
+			replyv.Elem().Set(r.reply.Elem())
+			return nil
+		case <-timer.C:
+			if sent >= max {
+				continue
+			}
+			if rpcAddr := xc.pickOther(used); rpcAddr != "" {
+				start(sent, rpcAddr, nil)
+				sent++
+				pending++
+				atomic.AddUint64(&xc.stats.hedges, 1)
+			}
+			if sent < max {
+				timer.Reset(xc.Hedge.Delay)
+			}
+		}
+	}
+	return lastErr
+}
+
+// 选一个还没有用过并且熔断器允许的服务器, 没有可用的服务器时返回空字符串
+func (xc *XClient) pickOther(used map[string]bool) string {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return ""
+	}
+	rand.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+	for _, rpcAddr := range servers {
+		if used[rpcAddr] {
+			continue
+		}
+		used[rpcAddr] = true
+		if xc.breakerOf(rpcAddr).allow() {
+			return rpcAddr
+		}
+	}
+	return ""
+}
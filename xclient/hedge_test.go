@@ -0,0 +1,106 @@
+package xclient
+
+import (
+	"GeeRPC/service"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 按 delay 延迟回复, 记录被取消的次数
+type Slow struct {
+	delay    time.Duration
+	canceled int32
+}
+
+func (s *Slow) Get(ctx context.Context, args int, reply *int) error {
+	select {
+	case <-time.After(s.delay):
+		*reply = args
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt32(&s.canceled, 1)
+		return ctx.Err()
+	}
+}
+
+func (s *Slow) Put(args int, reply *int) error {
+	time.Sleep(s.delay)
+	*reply = args
+	return nil
+}
+
+func (s *Slow) IdempotentMethods() []string { return []string{"Get"} }
+
+// 总是先选第一个服务器, 让测试结果确定
+type firstDiscovery struct {
+	*MultiServersDiscovery
+}
+
+func (d firstDiscovery) Get(SelectMode) (string, error) {
+	servers, _ := d.GetAll()
+	return servers[0], nil
+}
+
+func TestXClient_Hedge(t *testing.T) {
+	slow, fast := &Slow{delay: 2 * time.Second}, &Slow{delay: 0}
+	d := firstDiscovery{NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), slow), startTestServer(t, "mem", service.NewServer(), fast)})}
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Hedge = &HedgePolicy{Delay: 20 * time.Millisecond}
+
+	start := time.Now()
+	var reply int
+	if err := xc.Call(context.Background(), "Slow.Get", 42, &reply); err != nil || reply != 42 {
+		t.Fatalf("expect hedged reply, got %d (%v)", reply, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hedge should not wait for the slow server, took %s", d)
+	}
+	if s := xc.HedgeStats(); s != (HedgeStats{Calls: 1, Hedges: 1, Wins: 1}) {
+		t.Fatalf("unexpected hedge stats %+v", s)
+	}
+	// 落后的请求在服务端被取消
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&slow.canceled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&slow.canceled) != 1 {
+		t.Fatal("the losing request should be canceled on the server")
+	}
+}
+
+func TestXClient_HedgeNotIdempotent(t *testing.T) {
+	slow, fast := &Slow{delay: 100 * time.Millisecond}, &Slow{}
+	d := firstDiscovery{NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), slow), startTestServer(t, "mem", service.NewServer(), fast)})}
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Hedge = &HedgePolicy{Delay: 10 * time.Millisecond}
+
+	var reply int
+	if err := xc.Call(context.Background(), "Slow.Put", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("call failed: %d (%v)", reply, err)
+	}
+	if s := xc.HedgeStats(); s != (HedgeStats{}) {
+		t.Fatalf("non-idempotent method should not be hedged, got %+v", s)
+	}
+}
+
+func TestXClient_HedgeFirstWins(t *testing.T) {
+	fast, slow := &Slow{}, &Slow{delay: time.Second}
+	d := firstDiscovery{NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), fast), startTestServer(t, "mem", service.NewServer(), slow)})}
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Hedge = &HedgePolicy{Delay: 500 * time.Millisecond}
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		if err := xc.Call(context.Background(), "Slow.Get", i, &reply); err != nil || reply != i {
+			t.Fatalf("call failed: %d (%v)", reply, err)
+		}
+	}
+	if s := xc.HedgeStats(); s != (HedgeStats{Calls: 3}) {
+		t.Fatalf("fast replies should not trigger hedges, got %+v", s)
+	}
+}
@@ -0,0 +1,120 @@
+package xclient
+
+import (
+	"GeeRPC/service"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 重试策略: 只有幂等的方法 (服务端声明或客户端白名单) 在返回可重试的错误码时才会重试,
+// 每次重试都重新选择服务器, 连接断开时会重新建立连接
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多尝试的次数, 包括第一次, 小于等于 1 表示不重试
+	InitialBackoff time.Duration // 第一次重试前的等待时间
+	MaxBackoff     time.Duration // 等待时间的上限, 0 表示不限
+	Multiplier     float64       // 每次重试等待时间的倍数, 小于 1 时按 1 处理
+	Jitter         float64       // 等待时间随机浮动的比例, 取值 [0, 1]
+	RetryableCodes []service.Code
+	Idempotent     []string     // 客户端认为幂等的方法 "Service.Method", 与服务端声明的取并集
+	Budget         *RetryBudget // 为 nil 时不限制重试的比例
+}
+
+// 默认只重试 Unavailable, 即连接断开、服务端过载等没有执行或可以安全重做的情况
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []service.Code{service.Unavailable},
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := service.CodeOf(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) allowlisted(serviceMethod string) bool {
+	for _, m := range p.Idempotent {
+		if m == serviceMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 retry 次重试 (从 1 开始) 前的等待时间
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry && p.Multiplier > 1; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// 等待 d, ctx 先结束时返回 ctx 的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// RetryBudget 限制重试的比例, 防止服务端故障时重试把流量放大 (重试风暴)
+// 做法与 gRPC 的 retry throttling 相同: 令牌初始为 MaxTokens, 每次失败减 1, 每次成功加 TokenRatio,
+// 令牌数不超过 MaxTokens 的一半时不再重试
+type RetryBudget struct {
+	mu         sync.Mutex
+	maxTokens  float64
+	tokenRatio float64
+	tokens     float64
+}
+
+func NewRetryBudget(maxTokens, tokenRatio float64) *RetryBudget {
+	return &RetryBudget{maxTokens: maxTokens, tokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+func (b *RetryBudget) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.tokenRatio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+func (b *RetryBudget) onFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+}
+
+// 是否还允许重试
+func (b *RetryBudget) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.maxTokens/2
+}
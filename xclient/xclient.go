@@ -0,0 +1,214 @@
+// 支持负载均衡、重试、对冲和熔断的客户端, 按需连接 Discovery 中的多个服务器并复用连接
+package xclient
+
+import (
+	"GeeRPC/service"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type XClient struct {
+	d        Discovery
+	mode     SelectMode
+	opt      *service.Option
+	mu       sync.Mutex // protect following
+	clients  map[string]*service.Client
+	dialing  map[string]*dialCall // 正在建立的连接, 同一个地址只连接一次
+	breakers map[string]*breaker
+	closed   bool
+
+	// 以下策略为 nil 时不启用, 需要在调用之前设置
+	Retry   *RetryPolicy
+	Hedge   *HedgePolicy
+	Breaker *BreakerPolicy
+	stats   hedgeStats
+}
+
+var _ io.Closer = (*XClient)(nil)
+
+func NewXClient(d Discovery, mode SelectMode, opt *service.Option) *XClient {
+	return &XClient{
+		d:        d,
+		mode:     mode,
+		opt:      opt,
+		clients:  make(map[string]*service.Client),
+		dialing:  make(map[string]*dialCall),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (xc *XClient) Close() error {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.closed = true
+	for key, client := range xc.clients {
+		_ = client.Close()
+		delete(xc.clients, key)
+	}
+	return nil
+}
+
+// 一次正在进行的连接, done 关闭后 client 和 err 可读
+type dialCall struct {
+	done   chan struct{}
+	client *service.Client
+	err    error
+}
+
+var errClosed = service.Errorf(service.Unavailable, "rpc xclient: client is closed")
+
+// 复用已有的连接, 连接已经断开时重新建立
+// 连接最长可能阻塞 ConnectTimeout, 不能持有 xc.mu, 否则一个连不上的地址会卡住所有调用和熔断器;
+// 同一个地址同时只连接一次, 其他调用方等待这次连接的结果
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*service.Client, error) {
+	xc.mu.Lock()
+	if xc.closed {
+		xc.mu.Unlock()
+		return nil, errClosed
+	}
+	client := xc.clients[rpcAddr]
+	if client != nil && client.IsAvalable() {
+		xc.mu.Unlock()
+		return client, nil
+	}
+	if client != nil {
+		delete(xc.clients, rpcAddr)
+		_ = client.Close()
+	}
+	d := xc.dialing[rpcAddr]
+	if d != nil {
+		xc.mu.Unlock()
+		select {
+		case <-d.done:
+			return d.client, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	d = &dialCall{done: make(chan struct{})}
+	xc.dialing[rpcAddr] = d
+	xc.mu.Unlock()
+
+	d.client, d.err = service.XDial(rpcAddr, xc.opt)
+	xc.mu.Lock()
+	delete(xc.dialing, rpcAddr)
+	if d.err == nil {
+		if xc.closed {
+			_ = d.client.Close()
+			d.client, d.err = nil, errClosed
+		} else {
+			xc.clients[rpcAddr] = d.client
+		}
+	}
+	xc.mu.Unlock()
+	close(d.done)
+	return d.client, d.err
+}
+
+// 方法是否幂等: 服务端声明的, 或者在重试、对冲策略的白名单中
+func (xc *XClient) isIdempotent(client *service.Client, serviceMethod string) bool {
+	return client.IsIdempotent(serviceMethod) ||
+		xc.Retry != nil && xc.Retry.allowlisted(serviceMethod) ||
+		xc.Hedge != nil && xc.Hedge.allowlisted(serviceMethod)
+}
+
+// 与 gRPC 一样, 连接不上服务器视为 Unavailable
+func dialError(rpcAddr string, err error) error {
+	return service.Errorf(service.Unavailable, "rpc xclient: dial %s: %v", rpcAddr, err)
+}
+
+// 连接失败记录到熔断器; 调用方取消时不能说明服务器的状况
+func (xc *XClient) dialFailed(ctx context.Context, rpcAddr string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("rpc xclient: dial %s: %w", rpcAddr, ctx.Err())
+	}
+	err = dialError(rpcAddr, err)
+	xc.breakerOf(rpcAddr).done(err, 0)
+	return err
+}
+
+// 被服务端过载保护丢弃的请求没有执行, 与没有发出一样可以重试;
+// ErrShutdown 是在连接已经关闭后发起的调用, 请求没有写出去
+func notSent(err error) bool {
+	return service.IsShed(err) || errors.Is(err, service.ErrShutdown)
+}
+
+// 把 reply 重置为零值, 上一次请求解码了一半的结果不会留到重试的结果中
+func resetReply(reply interface{}) {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+}
+
+// 选择一个服务器调用一次, 设置了 Hedge 时幂等的方法会发送对冲请求
+// sent 表示请求是否已经发出, 连接没有建立起来时请求一定没有发出
+func (xc *XClient) call(ctx context.Context, serviceMethod string, args, reply interface{}, opts []service.CallOption) (sent, idempotent bool, err error) {
+	rpcAddr, err := xc.pick()
+	if err != nil {
+		return false, false, err
+	}
+	client, err := xc.dial(ctx, rpcAddr)
+	if err != nil {
+		return false, false, xc.dialFailed(ctx, rpcAddr, err)
+	}
+	idempotent = xc.isIdempotent(client, serviceMethod)
+	if xc.Hedge != nil && idempotent {
+		return true, true, xc.hedge(ctx, rpcAddr, client, serviceMethod, args, reply, opts)
+	}
+	return true, idempotent, xc.callAddr(ctx, rpcAddr, client, serviceMethod, args, reply, opts)
+}
+
+// 在 rpcAddr 上调用, 结果记录到它的熔断器
+func (xc *XClient) callAddr(ctx context.Context, rpcAddr string, client *service.Client, serviceMethod string, args, reply interface{}, opts []service.CallOption) error {
+	start := time.Now()
+	err := client.CallContext(ctx, serviceMethod, args, reply, opts...)
+	xc.breakerOf(rpcAddr).done(err, time.Since(start))
+	return err
+}
+
+// Call 选择一个服务器调用 serviceMethod, 设置了 Retry 时按重试策略重试
+// 请求没有发出或者被服务端丢弃时不论方法是否幂等都可以重试
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...service.CallOption) error {
+	p := xc.Retry
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			resetReply(reply)
+		}
+		sent, idempotent, err := xc.call(ctx, serviceMethod, args, reply, opts)
+		if p == nil {
+			return err
+		}
+		if err == nil {
+			if p.Budget != nil {
+				p.Budget.onSuccess()
+			}
+			return nil
+		}
+		if !p.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if p.Budget != nil {
+			p.Budget.onFailure()
+		}
+		if notSent(err) {
+			sent = false
+		}
+		if attempt >= p.MaxAttempts || sent && !idempotent || p.Budget != nil && !p.Budget.Allow() {
+			return err
+		}
+		// 服务端给出了 retry-after 时至少等待这么久
+		wait := p.backoff(attempt)
+		if d := service.RetryAfterOf(err); d > wait {
+			wait = d
+		}
+		if sleepContext(ctx, wait) != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package xclient
+
+import (
+	"GeeRPC/memconn"
+	"GeeRPC/service"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 前 failures 次调用返回 Unavailable
+type Flaky struct {
+	failures int32
+	calls    int32
+}
+
+func (f *Flaky) Get(args int, reply *int) error {
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return service.Errorf(service.Unavailable, "try again")
+	}
+	*reply = args
+	return nil
+}
+
+func (f *Flaky) Put(args int, reply *int) error { return f.Get(args, reply) }
+
+func (f *Flaky) IdempotentMethods() []string { return []string{"Get"} }
+
+// 启动测试用的服务器并注册 rcvrs, 与 service 包测试中的 startTestServer 相同,
+// 但返回 network@addr 形式的地址, 可以直接放进 Discovery
+func startTestServer(tb testing.TB, network string, server *service.Server, rcvrs ...interface{}) string {
+	tb.Helper()
+	var l net.Listener
+	var err error
+	if network == "mem" {
+		l, err = memconn.Listen("", nil)
+	} else {
+		l, err = net.Listen(network, "127.0.0.1:0")
+	}
+	if err != nil {
+		tb.Fatalf("listen %s failed: %v", network, err)
+	}
+	for _, rcvr := range rcvrs {
+		if err := server.Register(rcvr); err != nil {
+			tb.Fatalf("register failed: %v", err)
+		}
+	}
+	go server.Accept(l)
+	tb.Cleanup(func() { _ = l.Close() })
+	return network + "@" + l.Addr().String()
+}
+
+func fastRetry() *RetryPolicy {
+	p := *DefaultRetryPolicy
+	p.InitialBackoff = time.Millisecond
+	return &p
+}
+
+func TestXClient_RetryIdempotent(t *testing.T) {
+	f := &Flaky{failures: 2}
+	xc := NewXClient(NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), f)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Retry = fastRetry()
+
+	var reply int
+	if err := xc.Call(context.Background(), "Flaky.Get", 7, &reply); err != nil || reply != 7 {
+		t.Fatalf("expect success after retries, got %d (%v)", reply, err)
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 3 {
+		t.Fatalf("expect 3 attempts, got %d", n)
+	}
+
+	// 没有声明幂等的方法不重试
+	atomic.StoreInt32(&f.calls, 0)
+	err := xc.Call(context.Background(), "Flaky.Put", 7, &reply)
+	if service.CodeOf(err) != service.Unavailable || atomic.LoadInt32(&f.calls) != 1 {
+		t.Fatalf("non-idempotent method should not be retried, got %v after %d calls", err, f.calls)
+	}
+
+	// 客户端白名单
+	atomic.StoreInt32(&f.calls, 0)
+	xc.Retry.Idempotent = []string{"Flaky.Put"}
+	if err := xc.Call(context.Background(), "Flaky.Put", 7, &reply); err != nil {
+		t.Fatalf("allowlisted method should be retried, got %v", err)
+	}
+}
+
+func TestXClient_RetryMaxAttempts(t *testing.T) {
+	f := &Flaky{failures: 10}
+	xc := NewXClient(NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), f)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Retry = fastRetry()
+
+	var reply int
+	err := xc.Call(context.Background(), "Flaky.Get", 1, &reply)
+	if service.CodeOf(err) != service.Unavailable || atomic.LoadInt32(&f.calls) != 3 {
+		t.Fatalf("expect Unavailable after 3 attempts, got %v after %d calls", err, f.calls)
+	}
+}
+
+func TestXClient_RetryBudget(t *testing.T) {
+	f := &Flaky{failures: 100}
+	xc := NewXClient(NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), f)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Retry = fastRetry()
+	xc.Retry.MaxAttempts = 10
+	xc.Retry.Budget = NewRetryBudget(4, 0.1)
+
+	// 令牌从 4 开始, 不超过 2 时不再重试: 第一次调用尝试 2 次, 之后每次只尝试一次
+	var reply int
+	for i := 0; i < 3; i++ {
+		_ = xc.Call(context.Background(), "Flaky.Get", 1, &reply)
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 4 {
+		t.Fatalf("expect budget to cap attempts at 4, got %d", n)
+	}
+}
+
+func TestXClient_RetryDialFailure(t *testing.T) {
+	f := &Flaky{}
+	alive := startTestServer(t, "mem", service.NewServer(), f)
+	xc := NewXClient(NewMultiServerDiscovery([]string{"mem@no-such-server", alive}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Retry = fastRetry()
+
+	// 连接失败时请求没有发出, 不幂等的方法也可以换一个服务器重试
+	var reply int
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Flaky.Put", i, &reply); err != nil || reply != i {
+			t.Fatalf("expect retry on another server, got %d (%v)", reply, err)
+		}
+	}
+}
+
+func TestXClient_Redial(t *testing.T) {
+	f := &Flaky{}
+	addr := startTestServer(t, "mem", service.NewServer(), f)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	if err := xc.Call(context.Background(), "Flaky.Get", 1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// 断开已有连接后, 下一次调用重新建立连接
+	xc.mu.Lock()
+	_ = xc.clients[addr].Close()
+	xc.mu.Unlock()
+	if err := xc.Call(context.Background(), "Flaky.Get", 2, &reply); err != nil || reply != 2 {
+		t.Fatalf("expect redial, got %d (%v)", reply, err)
+	}
+}
+
+func TestXClient_DialNotBlocking(t *testing.T) {
+	// 不 Accept 的 listener, 连接会一直阻塞到 ConnectTimeout
+	l, err := memconn.Listen("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	blackhole := "mem@" + l.Addr().String()
+
+	f := &Flaky{}
+	opt := *service.DefaultOption
+	opt.ConnectTimeout = 2 * time.Second
+	xc := NewXClient(NewMultiServerDiscovery([]string{startTestServer(t, "mem", service.NewServer(), f)}), RandomSelect, &opt)
+	defer func() { _ = xc.Close() }()
+
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := xc.dial(context.Background(), blackhole)
+		dialed <- err
+	}()
+	for {
+		xc.mu.Lock()
+		d := xc.dialing[blackhole]
+		xc.mu.Unlock()
+		if d != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 其他服务器的调用和熔断器不受影响
+	start := time.Now()
+	var reply int
+	if err := xc.Call(context.Background(), "Flaky.Get", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("expect call to succeed, got %d (%v)", reply, err)
+	}
+	_ = xc.breakerOf(blackhole)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("call blocked by a pending dial for %s", d)
+	}
+
+	// 等待同一个地址的连接时可以取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := xc.dial(ctx, blackhole); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect waiter to give up with ctx, got %v", err)
+	}
+	if err := <-dialed; err == nil {
+		t.Fatal("expect dial to time out")
+	}
+}
+
+func TestNotSent(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{service.ErrShutdown, true},
+		{fmt.Errorf("rpc client: %w", service.ErrShutdown), true},
+		{&service.Error{Code: service.Unavailable, Shed: true}, true},
+		{service.Errorf(service.Unavailable, "try again"), false},
+		{io.ErrUnexpectedEOF, false},
+	} {
+		if got := notSent(tc.err); got != tc.want {
+			t.Fatalf("notSent(%v): expect %v, got %v", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestResetReply(t *testing.T) {
+	type pair struct{ A, B int }
+	reply := pair{A: 1, B: 2}
+	resetReply(&reply)
+	if reply != (pair{}) {
+		t.Fatalf("expect zero reply, got %+v", reply)
+	}
+	// 不是指针时什么也不做
+	resetReply(reply)
+	resetReply((*pair)(nil))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 2}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Fatalf("retry %d: expect %s, got %s", i+1, w*time.Millisecond, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("jittered backoff %s out of range", d)
+		}
+	}
+}